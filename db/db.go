@@ -21,8 +21,7 @@ func InitDB() {
 		panic("could not load in the .env")
 	}
 
-	dsn := fmt.Sprintf("host=localhost user=%s password=%s dbname=circle port=5432 sslmode=disable TimeZone=Asia/Shanghai", os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD"))
-	DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{
+	DB, err = gorm.Open(postgres.Open(DSN()), &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Info),
 	})
 
@@ -32,8 +31,39 @@ func InitDB() {
 
 	fmt.Println("\u2714 Connected to Database successfully")
 
-	err = DB.AutoMigrate(&models.User{}, &models.Post{}, &models.PostLike{}, &models.PostComment{})
+	err = Migrate(DB)
 	if err != nil {
 		log.Fatal("Failed to migrate database schema")
 	}
 }
+
+// DSN is the connection string for the application database.
+func DSN() string {
+	return fmt.Sprintf("host=localhost user=%s password=%s dbname=circle port=5432 sslmode=disable TimeZone=Asia/Shanghai", os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD"))
+}
+
+// Migrate brings the schema for every model up to date.
+func Migrate(db *gorm.DB) error {
+	return db.AutoMigrate(
+		&models.User{},
+		&models.Post{},
+		&models.PostLike{},
+		&models.PostComment{},
+		&models.Session{},
+		&models.RefreshToken{},
+		&models.FriendRequest{},
+		&models.ChatMessage{},
+		&models.ChatRoom{},
+		&models.Notification{},
+		&models.NotificationActor{},
+		&models.OutboxEmail{},
+		&models.EmailVerification{},
+		&models.PasswordReset{},
+		&models.AuditEvent{},
+		&models.TwoFactor{},
+		&models.RecoveryCode{},
+		&models.TwoFactorChallenge{},
+		&models.SigninThrottle{},
+		&models.PersonalAccessToken{},
+	)
+}
@@ -0,0 +1,138 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/tenkorangjr/circle-app/db"
+	"github.com/tenkorangjr/circle-app/models"
+	"gorm.io/gorm"
+)
+
+// Message is a single email as handed to a Transport.
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Body    string
+}
+
+// Transport delivers a message, or fails so that it can be retried.
+type Transport interface {
+	Send(ctx context.Context, message Message) error
+}
+
+//go:embed templates/*.tmpl
+var templateFiles embed.FS
+
+// templates maps a name such as "welcome" to templates/welcome.tmpl, which
+// defines a "subject" and a "body".
+var templates = loadTemplates()
+
+func loadTemplates() map[string]*template.Template {
+	entries, err := templateFiles.ReadDir("templates")
+	if err != nil {
+		panic(err)
+	}
+
+	result := make(map[string]*template.Template, len(entries))
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".tmpl")
+		result[name] = template.Must(template.ParseFS(templateFiles, "templates/"+entry.Name()))
+	}
+	return result
+}
+
+// Render fills in the named template's subject and body with data.
+func Render(name string, data interface{}) (subject, body string, err error) {
+	tmpl, ok := templates[name]
+	if !ok {
+		return "", "", fmt.Errorf("unknown email template %q", name)
+	}
+
+	var buffer bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buffer, "subject", data); err != nil {
+		return "", "", err
+	}
+	subject = strings.TrimSpace(buffer.String())
+
+	buffer.Reset()
+	if err := tmpl.ExecuteTemplate(&buffer, "body", data); err != nil {
+		return "", "", err
+	}
+	body = strings.TrimSpace(buffer.String()) + "\n"
+
+	return subject, body, nil
+}
+
+// Queue renders the named template for to and writes it to the outbox, to
+// be sent in the background. Pass a transaction as db to only send the email
+// if the rest of it commits.
+func Queue(db *gorm.DB, to, name string, data interface{}) error {
+	subject, body, err := Render(name, data)
+	if err != nil {
+		return err
+	}
+
+	return db.Create(models.NewOutboxEmail(to, name, subject, body)).Error
+}
+
+// Outbox is the sender started by Init.
+var Outbox *Sender
+
+// Init starts sending the outbox through the transport configured in the
+// environment (see TransportFromEnv).
+func Init() {
+	transport, err := TransportFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	Outbox = NewSender(db.DB, transport, getenv("MAIL_FROM", "Circle <no-reply@circle.app>"))
+	go Outbox.Run(context.Background(), 5*time.Second)
+}
+
+// TransportFromEnv returns the transport named by MAIL_TRANSPORT: "smtp",
+// "file" to write each message to MAIL_DIR, or "memory" to keep them in the
+// process. Left unset, it is "smtp" if SMTP_HOST is configured and "file"
+// otherwise, so that development needs no mail server.
+func TransportFromEnv() (Transport, error) {
+	defaultTransport := "file"
+	if os.Getenv("SMTP_HOST") != "" {
+		defaultTransport = "smtp"
+	}
+
+	switch name := getenv("MAIL_TRANSPORT", defaultTransport); name {
+	case "smtp":
+		host := os.Getenv("SMTP_HOST")
+		if host == "" {
+			return nil, errors.New("SMTP_HOST must be set to send mail over SMTP")
+		}
+		return NewSMTPTransport(host, getenv("SMTP_PORT", "587"), os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD")), nil
+	case "file":
+		fileTransport, err := NewFileTransport(getenv("MAIL_DIR", "./mail-out"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up file mail transport: %w", err)
+		}
+		return fileTransport, nil
+	case "memory":
+		return NewMemoryTransport(), nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_TRANSPORT %q", name)
+	}
+}
+
+func getenv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
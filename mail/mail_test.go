@@ -0,0 +1,134 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tenkorangjr/circle-app/db"
+	"github.com/tenkorangjr/circle-app/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupOutbox(t *testing.T) *gorm.DB {
+	database, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	sqlDB, _ := database.DB()
+	sqlDB.SetMaxOpenConns(1)
+
+	db.Migrate(database)
+	return database
+}
+
+type failingTransport struct{}
+
+func (failingTransport) Send(ctx context.Context, message Message) error {
+	return errors.New("relay unavailable")
+}
+
+func TestOutboxSendsQueuedMail(t *testing.T) {
+	database := setupOutbox(t)
+	transport := NewMemoryTransport()
+	sender := NewSender(database, transport, "Circle <no-reply@circle.app>")
+
+	assert.NoError(t, Queue(database, "ana@circle.app", "welcome", map[string]string{"Email": "ana@circle.app"}))
+	assert.Error(t, Queue(database, "ana@circle.app", "no-such-template", nil))
+
+	sent, err := sender.SendDue(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, sent)
+
+	messages := transport.Sent()
+	assert.Len(t, messages, 1)
+	assert.Equal(t, "ana@circle.app", messages[0].To)
+	assert.Equal(t, "Welcome to Circle", messages[0].Subject)
+	assert.Contains(t, messages[0].Body, "Hi ana@circle.app,")
+
+	// nothing is sent twice
+	sent, _ = sender.SendDue(context.Background())
+	assert.Equal(t, 0, sent)
+
+	var email models.OutboxEmail
+	database.First(&email)
+	assert.Equal(t, models.OutboxSent, email.Status)
+	assert.NotNil(t, email.SentAt)
+}
+
+func TestOutboxRetriesWithBackoffThenGivesUp(t *testing.T) {
+	database := setupOutbox(t)
+	sender := NewSender(database, failingTransport{}, "no-reply@circle.app")
+	Queue(database, "ana@circle.app", "welcome", map[string]string{"Email": "ana@circle.app"})
+
+	now := time.Now()
+	sender.now = func() time.Time { return now }
+
+	var email models.OutboxEmail
+	for attempt := 1; attempt <= maxSendAttempts; attempt++ {
+		sent, err := sender.SendDue(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 0, sent)
+
+		database.First(&email)
+		assert.Equal(t, attempt, email.Attempts)
+		assert.Equal(t, "relay unavailable", email.LastError)
+		if attempt < maxSendAttempts {
+			assert.Equal(t, models.OutboxPending, email.Status)
+			assert.WithinDuration(t, now.Add(backoff(attempt)), email.NextAttemptAt, time.Second)
+		}
+
+		// not due again until the backoff has passed
+		sent, _ = sender.SendDue(context.Background())
+		assert.Equal(t, 0, sent)
+		now = email.NextAttemptAt
+	}
+
+	assert.Equal(t, models.OutboxFailed, email.Status)
+	assert.Equal(t, 2*retryBackoff, backoff(2))
+	assert.Equal(t, maxRetryBackoff, backoff(30))
+}
+
+func TestFileTransportWritesMessages(t *testing.T) {
+	dir := t.TempDir()
+	transport, err := NewFileTransport(dir)
+	assert.NoError(t, err)
+
+	assert.NoError(t, transport.Send(context.Background(), Message{
+		From:    "no-reply@circle.app",
+		To:      "ana@circle.app",
+		Subject: "Hello",
+		Body:    "line one\nline two\n",
+	}))
+
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 1)
+	content, _ := os.ReadFile(dir + "/" + entries[0].Name())
+	assert.True(t, strings.HasPrefix(string(content), "From: no-reply@circle.app\r\nTo: ana@circle.app\r\nSubject: Hello\r\n"))
+	assert.Contains(t, string(content), "\r\n\r\nline one\r\nline two\r\n")
+}
+
+func TestTransportDefaultsToFilesWithoutSMTP(t *testing.T) {
+	t.Setenv("MAIL_TRANSPORT", "")
+	t.Setenv("SMTP_HOST", "")
+	t.Setenv("MAIL_DIR", t.TempDir())
+
+	transport, err := TransportFromEnv()
+	assert.NoError(t, err)
+	assert.IsType(t, &FileTransport{}, transport)
+
+	t.Setenv("SMTP_HOST", "smtp.example.com")
+	transport, err = TransportFromEnv()
+	assert.NoError(t, err)
+	assert.IsType(t, &SMTPTransport{}, transport)
+
+	t.Setenv("SMTP_HOST", "")
+	t.Setenv("MAIL_TRANSPORT", "smtp")
+	_, err = TransportFromEnv()
+	assert.Error(t, err)
+}
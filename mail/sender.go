@@ -0,0 +1,125 @@
+package mail
+
+import (
+	"context"
+	"time"
+
+	"github.com/tenkorangjr/circle-app/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	// emails picked up per pass over the outbox
+	sendBatchSize = 50
+	// how long a claimed email is held before another sender may retry it
+	sendLease = time.Minute
+	// attempts before an email is given up on
+	maxSendAttempts = 8
+	// delay before the first retry, doubling with each one after it
+	retryBackoff    = 30 * time.Second
+	maxRetryBackoff = 6 * time.Hour
+)
+
+// Sender works through the outbox, handing due emails to a transport and
+// retrying failures with exponential backoff. Several senders may share an
+// outbox; each email is claimed before it is sent.
+type Sender struct {
+	db        *gorm.DB
+	transport Transport
+	from      string
+	now       func() time.Time
+}
+
+func NewSender(db *gorm.DB, transport Transport, from string) *Sender {
+	return &Sender{
+		db:        db,
+		transport: transport,
+		from:      from,
+		now:       time.Now,
+	}
+}
+
+// Run sends due emails every interval until ctx is done.
+func (s *Sender) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.SendDue(ctx); err != nil {
+			zap.S().Errorf("failed to process the outbox: %v", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// SendDue makes one pass over the outbox and returns how many emails went
+// out.
+func (s *Sender) SendDue(ctx context.Context) (int, error) {
+	now := s.now()
+	emails, err := models.DueEmails(s.db, now, sendBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for i := range emails {
+		email := &emails[i]
+
+		claimed, err := email.Claim(s.db, now.Add(sendLease))
+		if err != nil {
+			return sent, err
+		}
+		if !claimed {
+			continue
+		}
+
+		if s.send(ctx, email) {
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+func (s *Sender) send(ctx context.Context, email *models.OutboxEmail) bool {
+	err := s.transport.Send(ctx, Message{
+		From:    s.from,
+		To:      email.To,
+		Subject: email.Subject,
+		Body:    email.Body,
+	})
+	if err == nil {
+		if err := email.MarkSent(s.db); err != nil {
+			zap.S().Errorf("email %d was sent but could not be marked sent: %v", email.ID, err)
+		}
+		return true
+	}
+
+	var retryAt *time.Time
+	if email.Attempts < maxSendAttempts {
+		next := s.now().Add(backoff(email.Attempts))
+		retryAt = &next
+		zap.S().Warnf("failed to send email %d (attempt %d), retrying at %s: %v", email.ID, email.Attempts, next.Format(time.RFC3339), err)
+	} else {
+		zap.S().Errorf("giving up on email %d after %d attempts: %v", email.ID, email.Attempts, err)
+	}
+
+	if err := email.MarkFailed(s.db, err, retryAt); err != nil {
+		zap.S().Errorf("failed to record failure of email %d: %v", email.ID, err)
+	}
+	return false
+}
+
+// backoff is the wait after the given number of failed attempts.
+func backoff(attempts int) time.Duration {
+	delay := retryBackoff
+	for i := 1; i < attempts && delay < maxRetryBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryBackoff)
+}
@@ -0,0 +1,107 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SMTPTransport sends mail through an SMTP relay, authenticating with PLAIN
+// auth when a username is set.
+type SMTPTransport struct {
+	addr string
+	auth smtp.Auth
+}
+
+func NewSMTPTransport(host, port, username, password string) *SMTPTransport {
+	transport := &SMTPTransport{addr: net.JoinHostPort(host, port)}
+	if username != "" {
+		transport.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return transport
+}
+
+// Send ignores ctx, as net/smtp has no way to cancel a send in progress.
+func (t *SMTPTransport) Send(ctx context.Context, message Message) error {
+	from, err := envelopeAddress(message.From)
+	if err != nil {
+		return err
+	}
+
+	return smtp.SendMail(t.addr, t.auth, from, []string{message.To}, format(message))
+}
+
+// FileTransport writes each message to its own .eml file in a directory,
+// for development without a mail server.
+type FileTransport struct {
+	dir string
+}
+
+func NewFileTransport(dir string) (*FileTransport, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileTransport{dir: dir}, nil
+}
+
+func (t *FileTransport) Send(ctx context.Context, message Message) error {
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), strings.ReplaceAll(message.To, "@", "_at_"))
+	return os.WriteFile(filepath.Join(t.dir, name), format(message), 0o644)
+}
+
+// MemoryTransport keeps sent messages in memory so tests can inspect them.
+type MemoryTransport struct {
+	mutex    sync.Mutex
+	messages []Message
+}
+
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{}
+}
+
+func (t *MemoryTransport) Send(ctx context.Context, message Message) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.messages = append(t.messages, message)
+	return nil
+}
+
+// Sent returns every message sent so far, oldest first.
+func (t *MemoryTransport) Sent() []Message {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return append([]Message{}, t.messages...)
+}
+
+// format lays the message out as a plain text RFC 5322 email.
+func format(message Message) []byte {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "From: %s\r\n", message.From)
+	fmt.Fprintf(&builder, "To: %s\r\n", message.To)
+	fmt.Fprintf(&builder, "Subject: %s\r\n", message.Subject)
+	fmt.Fprintf(&builder, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	builder.WriteString("MIME-Version: 1.0\r\n")
+	builder.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	builder.WriteString("\r\n")
+	builder.WriteString(strings.ReplaceAll(message.Body, "\n", "\r\n"))
+
+	return []byte(builder.String())
+}
+
+// envelopeAddress pulls the bare address out of a "Name <address>" sender.
+func envelopeAddress(from string) (string, error) {
+	address, err := netmail.ParseAddress(from)
+	if err != nil {
+		return "", fmt.Errorf("invalid sender %q: %w", from, err)
+	}
+	return address.Address, nil
+}
@@ -1,9 +1,17 @@
 package main
 
 import (
+	"log"
+	"os"
+	"strings"
+
 	"github.com/gin-gonic/gin"
 	"github.com/tenkorangjr/circle-app/db"
+	"github.com/tenkorangjr/circle-app/mail"
 	"github.com/tenkorangjr/circle-app/routes"
+	"github.com/tenkorangjr/circle-app/routes/websockets"
+	"github.com/tenkorangjr/circle-app/storage"
+	"github.com/tenkorangjr/circle-app/utils"
 	"go.uber.org/zap"
 )
 
@@ -16,9 +24,31 @@ func main() {
 	zap.ReplaceGlobals(logger)
 
 	db.InitDB()
+	utils.InitSigningKeys()
+	storage.Init()
+	mail.Init()
+	websockets.Configure(websockets.ConfigFromEnv())
+	websockets.InitBus()
 	server := gin.Default()
+	// client IPs key rate limits and signin throttling, so forwarded headers
+	// are only believed from proxies we run
+	if err := server.SetTrustedProxies(trustedProxies()); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
 
 	routes.RegisterRoutes(server)
 
 	server.Run(":8080")
 }
+
+// trustedProxies reads TRUSTED_PROXIES, a comma separated list of IPs or
+// CIDRs. None are trusted by default.
+func trustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
@@ -1,28 +1,145 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/tenkorangjr/circle-app/db"
+	"github.com/tenkorangjr/circle-app/models"
 	"github.com/tenkorangjr/circle-app/utils"
+	"go.uber.org/zap"
 )
 
 func Authenticate(context *gin.Context) {
 	token := context.GetHeader("authorization")
-	if token == "" {
+	fromHeader := token != ""
+	if !fromHeader {
 		token = context.Query("token")
 		if token == "" {
-			context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "unauthorized user"})
+			unauthorized(context, "unauthorized user")
 			return
 		}
 	}
 
-	userId, err := utils.ValidateToken(token)
+	if strings.HasPrefix(token, models.PersonalAccessTokenPrefix) {
+		// they live too long to be left in URLs, which end up in logs
+		if !fromHeader {
+			unauthorized(context, "personal access tokens must be sent in the Authorization header")
+			return
+		}
+		authenticateAccessToken(context, token)
+		return
+	}
+
+	claims, err := utils.ValidateToken(token)
+	if err != nil {
+		unauthorized(context, tokenErrorMessage(err))
+		return
+	}
+
+	if !models.IsSessionActive(db.DB, claims.SessionID) {
+		unauthorized(context, "session has been revoked")
+		return
+	}
+
+	context.Set("userId", claims.UserID())
+	context.Set("sessionId", claims.SessionID)
+	context.Next()
+}
+
+// authenticateAccessToken lets a personal access token through, as long as
+// it has the scope the request needs: read for safe methods, write for
+// everything else.
+func authenticateAccessToken(context *gin.Context, token string) {
+	accessToken, err := models.UsePersonalAccessToken(db.DB, token, context.ClientIP())
+	if errors.Is(err, models.ErrInvalidAccessToken) {
+		unauthorized(context, err.Error())
+		return
+	}
 	if err != nil {
-		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "unauthorized user"})
+		zap.S().Error("Failed to check personal access token", zap.Error(err))
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "could not authenticate"})
+		return
+	}
+
+	context.Set("userId", accessToken.UserID)
+	context.Set("accessToken", accessToken)
+
+	scope := models.ScopeWrite
+	switch context.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		scope = models.ScopeRead
+	}
+	if !checkScope(context, scope) {
 		return
 	}
 
-	context.Set("userId", userId)
 	context.Next()
 }
+
+// RequireScope holds personal access tokens to scope on a route whose method
+// doesn't say what it does, like the /chat websocket, which is opened with a
+// GET but sends messages. Signed in users have every scope. It must run
+// after Authenticate.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		if !checkScope(context, scope) {
+			return
+		}
+
+		context.Next()
+	}
+}
+
+// checkScope aborts the request if it was made with a personal access token
+// that lacks scope, and reports whether it may go on.
+func checkScope(context *gin.Context, scope string) bool {
+	value, ok := context.Get("accessToken")
+	if !ok {
+		return true
+	}
+
+	if accessToken := value.(*models.PersonalAccessToken); !accessToken.Allows(scope) {
+		context.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "access token lacks the " + scope + " scope"})
+		return false
+	}
+	return true
+}
+
+// RequireSession keeps personal access tokens away from a route, for things
+// like managing the account's credentials that only a signed in user should
+// do. It must run after Authenticate.
+func RequireSession(context *gin.Context) {
+	if context.GetUint("sessionId") == 0 {
+		context.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "sign in to do this"})
+		return
+	}
+
+	context.Next()
+}
+
+func unauthorized(context *gin.Context, message string) {
+	context.Header("WWW-Authenticate", "Bearer")
+	context.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": message})
+}
+
+// tokenErrorMessage says what was wrong with a token without echoing
+// anything about its contents.
+func tokenErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, utils.ErrTokenExpired):
+		return "token has expired"
+	case errors.Is(err, utils.ErrTokenNotYetValid):
+		return "token is not valid yet"
+	case errors.Is(err, utils.ErrTokenWrongAudience), errors.Is(err, utils.ErrTokenWrongIssuer):
+		return "token is not valid for this service"
+	case errors.Is(err, utils.ErrTokenWrongType):
+		return "token is not an access token"
+	case errors.Is(err, utils.ErrTokenMalformed):
+		return "token is malformed"
+	default:
+		return "token is invalid"
+	}
+}
@@ -1,32 +1,144 @@
 package middleware
 
 import (
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// RateLimitPolicy allows Limit requests every Per for each client of a route,
+// with Burst (defaulting to Limit) capping how many can be made back to back.
+type RateLimitPolicy struct {
+	Limit int
+	Per   time.Duration
+	Burst int
+}
+
+type bucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+type rateLimiter struct {
+	policy    RateLimitPolicy
+	rate      float64 // tokens per second
+	capacity  float64
+	idleAfter time.Duration
+
+	mutex     sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// RateLimiter limits each client to limit requests per duration on every
+// route it is attached to.
 func RateLimiter(limit int, duration time.Duration) gin.HandlerFunc {
-	tokens := make(chan struct{}, limit)
-
-	go func() {
-		ticker := time.NewTicker(time.Millisecond * 50)
-		defer ticker.Stop()
-		for range ticker.C {
-			select {
-			case tokens <- struct{}{}:
-			default:
-			}
-		}
-	}()
+	return RateLimitWithPolicy(RateLimitPolicy{Limit: limit, Per: duration})
+}
+
+// RateLimitWithPolicy returns a token-bucket limiter keyed by route and
+// client. Authenticated clients are identified by userId, so it must run after
+// Authenticate on protected routes; everyone else is keyed by IP.
+func RateLimitWithPolicy(policy RateLimitPolicy) gin.HandlerFunc {
+	limiter := newRateLimiter(policy)
 
 	return func(ctx *gin.Context) {
-		select {
-		case <-tokens:
-			ctx.Next()
-		default:
+		allowed, remaining, retryAfter := limiter.allow(limiterKey(ctx))
+
+		ctx.Header("X-RateLimit-Limit", strconv.Itoa(policy.Limit))
+		ctx.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		ctx.Header("X-RateLimit-Reset", strconv.Itoa(limiter.secondsUntilFull(remaining)))
+
+		if !allowed {
+			ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
 			ctx.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"message": "Rate limit exceeded"})
+			return
 		}
+
+		ctx.Next()
+	}
+}
+
+func newRateLimiter(policy RateLimitPolicy) *rateLimiter {
+	if policy.Burst <= 0 {
+		policy.Burst = policy.Limit
 	}
+
+	rate := float64(policy.Limit) / policy.Per.Seconds()
+	capacity := float64(policy.Burst)
+
+	return &rateLimiter{
+		policy:    policy,
+		rate:      rate,
+		capacity:  capacity,
+		idleAfter: time.Duration(capacity / rate * float64(time.Second)),
+		buckets:   make(map[string]*bucket),
+		now:       time.Now,
+	}
+}
+
+func limiterKey(ctx *gin.Context) string {
+	route := ctx.FullPath()
+	if route == "" {
+		route = ctx.Request.URL.Path
+	}
+
+	if userId := ctx.GetUint("userId"); userId != 0 {
+		return fmt.Sprintf("%s %s|user:%d", ctx.Request.Method, route, userId)
+	}
+	return fmt.Sprintf("%s %s|ip:%s", ctx.Request.Method, route, ctx.ClientIP())
+}
+
+// allow takes a token from the key's bucket if one is available, returning
+// how many are left or, when refused, how long until the next one.
+func (l *rateLimiter) allow(key string) (bool, int, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.capacity, lastSeen: now}
+		l.buckets[key] = b
+	}
+
+	elapsed := now.Sub(b.lastSeen).Seconds()
+	b.tokens = math.Min(l.capacity, b.tokens+elapsed*l.rate)
+	b.lastSeen = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, 0, wait
+	}
+
+	b.tokens--
+	return true, int(b.tokens), 0
+}
+
+// sweep drops buckets that have been idle long enough to refill completely,
+// since they are indistinguishable from a fresh bucket.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.idleAfter {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) >= l.idleAfter {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func (l *rateLimiter) secondsUntilFull(remaining int) int {
+	missing := l.capacity - float64(remaining)
+	return int(math.Ceil(missing / l.rate))
 }
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiterRefillsOverDuration(t *testing.T) {
+	limiter := newRateLimiter(RateLimitPolicy{Limit: 2, Per: time.Second})
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+
+	allowed, remaining, _ := limiter.allow("client")
+	assert.True(t, allowed)
+	assert.Equal(t, 1, remaining)
+
+	allowed, _, _ = limiter.allow("client")
+	assert.True(t, allowed)
+
+	allowed, _, retryAfter := limiter.allow("client")
+	assert.False(t, allowed)
+	assert.Equal(t, 500*time.Millisecond, retryAfter)
+
+	now = now.Add(500 * time.Millisecond)
+	allowed, _, _ = limiter.allow("client")
+	assert.True(t, allowed)
+}
+
+func TestRateLimiterEvictsIdleBuckets(t *testing.T) {
+	limiter := newRateLimiter(RateLimitPolicy{Limit: 1, Per: time.Minute})
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+
+	limiter.allow("first")
+	limiter.allow("second")
+	assert.Len(t, limiter.buckets, 2)
+
+	now = now.Add(2 * time.Minute)
+	limiter.allow("third")
+	assert.Len(t, limiter.buckets, 1)
+}
+
+func TestRateLimiterKeysByClient(t *testing.T) {
+	server := gin.New()
+	server.GET("/ping", RateLimiter(1, time.Minute), func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/ping", nil)
+		req.RemoteAddr = remoteAddr
+		responseWriter := httptest.NewRecorder()
+		server.ServeHTTP(responseWriter, req)
+		return responseWriter
+	}
+
+	assert.Equal(t, http.StatusOK, request("10.0.0.1:1000").Code)
+
+	limited := request("10.0.0.1:1000")
+	assert.Equal(t, http.StatusTooManyRequests, limited.Code)
+	assert.Equal(t, "60", limited.Header().Get("Retry-After"))
+	assert.Equal(t, "0", limited.Header().Get("X-RateLimit-Remaining"))
+
+	// a different client has its own bucket
+	assert.Equal(t, http.StatusOK, request("10.0.0.2:1000").Code)
+}
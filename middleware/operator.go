@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tenkorangjr/circle-app/db"
+	"github.com/tenkorangjr/circle-app/models"
+)
+
+// RequireOperator keeps everyone but the people running the server away from
+// the route, for things like server-wide metrics. Operators are the users
+// whose verified email address is listed in OPERATOR_EMAILS, a comma
+// separated list that is empty by default. It must run after Authenticate.
+func RequireOperator() gin.HandlerFunc {
+	operators := operatorEmails()
+
+	return func(ctx *gin.Context) {
+		if !models.HasVerifiedEmailIn(db.DB, ctx.GetUint("userId"), operators) {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "only operators can do this"})
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+func operatorEmails() []string {
+	var emails []string
+	for _, email := range strings.Split(os.Getenv("OPERATOR_EMAILS"), ",") {
+		if email = strings.TrimSpace(email); email != "" {
+			emails = append(emails, email)
+		}
+	}
+	return emails
+}
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tenkorangjr/circle-app/db"
+	"github.com/tenkorangjr/circle-app/models"
+)
+
+// Actions that can be withheld from users who haven't verified their email
+// address.
+const (
+	ActionPost    = "post"
+	ActionChat    = "chat"
+	ActionFriends = "friends"
+)
+
+const defaultUnverifiedRestrictions = ActionPost + "," + ActionChat
+
+// RequireVerifiedEmail keeps users who haven't verified their email address
+// away from the route, if action is one of those restricted for them. The
+// restricted actions are read from UNVERIFIED_RESTRICTIONS, a comma separated
+// list defaulting to "post,chat"; "none" restricts nothing. It must run after
+// Authenticate.
+func RequireVerifiedEmail(action string) gin.HandlerFunc {
+	if !unverifiedRestrictions()[action] {
+		return func(ctx *gin.Context) {
+			ctx.Next()
+		}
+	}
+
+	return func(ctx *gin.Context) {
+		if !models.IsEmailVerified(db.DB, ctx.GetUint("userId")) {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "verify your email address to do this"})
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+func unverifiedRestrictions() map[string]bool {
+	raw, ok := os.LookupEnv("UNVERIFIED_RESTRICTIONS")
+	if !ok {
+		raw = defaultUnverifiedRestrictions
+	}
+
+	restricted := make(map[string]bool)
+	for _, action := range strings.Split(raw, ",") {
+		if action = strings.TrimSpace(action); action != "" && action != "none" {
+			restricted[action] = true
+		}
+	}
+	return restricted
+}
@@ -0,0 +1,134 @@
+package models
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tenkorangjr/circle-app/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	// PersonalAccessTokenPrefix marks a token as a personal access token
+	// rather than a JWT, and makes leaked ones easy to search for.
+	PersonalAccessTokenPrefix = "circle_pat_"
+
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+
+	// last use is only written this often, not on every request
+	accessTokenUseInterval = time.Minute
+)
+
+var (
+	ErrAccessTokenNotFound = errors.New("access token not found")
+	ErrInvalidAccessToken  = errors.New("invalid or expired access token")
+)
+
+// PersonalAccessToken lets a script act as a user without signing in. Only a
+// hash of the token is stored, so it can only be shown when created.
+type PersonalAccessToken struct {
+	gorm.Model
+
+	UserID     uint       `gorm:"index" json:"-"`
+	Name       string     `json:"name"`
+	TokenHash  string     `gorm:"uniqueIndex" json:"-"`
+	Hint       string     `json:"hint"`
+	Scopes     []string   `gorm:"serializer:json" json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `json:"last_used_ip"`
+}
+
+// CreatePersonalAccessToken issues userId a token with the given scopes,
+// expiring at expiresAt or never if it is nil. It returns the token itself
+// alongside its record.
+func CreatePersonalAccessToken(db *gorm.DB, userId uint, name string, scopes []string, expiresAt *time.Time) (*PersonalAccessToken, string, error) {
+	secret, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return nil, "", err
+	}
+	token := PersonalAccessTokenPrefix + secret
+
+	accessToken := PersonalAccessToken{
+		UserID:    userId,
+		Name:      name,
+		TokenHash: utils.HashToken(token),
+		Hint:      token[:len(PersonalAccessTokenPrefix)+4],
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+	if err := db.Create(&accessToken).Error; err != nil {
+		return nil, "", err
+	}
+
+	return &accessToken, token, nil
+}
+
+func ListPersonalAccessTokens(db *gorm.DB, userId uint) ([]PersonalAccessToken, error) {
+	var accessTokens []PersonalAccessToken
+	err := db.Where("user_id = ?", userId).
+		Order("created_at DESC").
+		Find(&accessTokens).Error
+
+	return accessTokens, err
+}
+
+func RevokePersonalAccessToken(db *gorm.DB, userId, accessTokenId uint) error {
+	result := db.Where("id = ? AND user_id = ?", accessTokenId, userId).Delete(&PersonalAccessToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAccessTokenNotFound
+	}
+
+	return nil
+}
+
+func RevokeUserPersonalAccessTokens(db *gorm.DB, userId uint) error {
+	return db.Where("user_id = ?", userId).Delete(&PersonalAccessToken{}).Error
+}
+
+// UsePersonalAccessToken looks up an unexpired token and notes that it was
+// just used from ip.
+func UsePersonalAccessToken(db *gorm.DB, token, ip string) (*PersonalAccessToken, error) {
+	if !strings.HasPrefix(token, PersonalAccessTokenPrefix) {
+		return nil, ErrInvalidAccessToken
+	}
+
+	var accessToken PersonalAccessToken
+	err := db.Where("token_hash = ?", utils.HashToken(token)).First(&accessToken).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidAccessToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if accessToken.ExpiresAt != nil && !now.Before(*accessToken.ExpiresAt) {
+		return nil, ErrInvalidAccessToken
+	}
+
+	if accessToken.LastUsedAt == nil || now.Sub(*accessToken.LastUsedAt) >= accessTokenUseInterval || accessToken.LastUsedIP != ip {
+		accessToken.LastUsedAt = &now
+		accessToken.LastUsedIP = ip
+		err := db.Model(&accessToken).Updates(map[string]interface{}{
+			"last_used_at": now,
+			"last_used_ip": ip,
+		}).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &accessToken, nil
+}
+
+// Allows reports whether the token grants scope. Write access implies read.
+func (t *PersonalAccessToken) Allows(scope string) bool {
+	return slices.Contains(t.Scopes, scope) || (scope == ScopeRead && slices.Contains(t.Scopes, ScopeWrite))
+}
@@ -0,0 +1,39 @@
+package models
+
+import "gorm.io/gorm"
+
+const (
+	AuditPasswordResetRequested = "password_reset_requested"
+	AuditPasswordReset          = "password_reset"
+	AuditTwoFactorEnabled       = "two_factor_enabled"
+	AuditTwoFactorDisabled      = "two_factor_disabled"
+	AuditAccountLocked          = "account_locked"
+	AuditIPLocked               = "ip_locked"
+	AuditAccessTokenCreated     = "access_token_created"
+	AuditAccessTokenRevoked     = "access_token_revoked"
+)
+
+// AuditEvent is a record of something security sensitive happening to an
+// account, kept so that it can be looked into later. UserID is zero for
+// events that aren't about any one account, such as an IP being locked out.
+type AuditEvent struct {
+	gorm.Model
+
+	UserID    uint   `gorm:"index"`
+	Action    string `gorm:"index"`
+	IP        string
+	UserAgent string
+}
+
+func NewAuditEvent(userId uint, action, ip, userAgent string) *AuditEvent {
+	return &AuditEvent{
+		UserID:    userId,
+		Action:    action,
+		IP:        ip,
+		UserAgent: userAgent,
+	}
+}
+
+func (e *AuditEvent) Save(db *gorm.DB) error {
+	return db.Create(e).Error
+}
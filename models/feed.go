@@ -0,0 +1,90 @@
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// FeedPost is a post as it appears in someone's feed, with its author and
+// engagement counts resolved in the same query.
+type FeedPost struct {
+	ID           uint      `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	Caption      string    `json:"caption"`
+	ImageURL     string    `json:"-"`
+	UserID       uint      `json:"user_id"`
+	AuthorEmail  string    `json:"author_email"`
+	LikeCount    int64     `json:"like_count"`
+	CommentCount int64     `json:"comment_count"`
+	SignedURL    string    `json:"signed_url" gorm:"-"`
+}
+
+// FeedCursor marks the last post of a page. Posts are ordered by creation
+// time with the ID breaking ties, so the pair is a stable position.
+type FeedCursor struct {
+	CreatedAt time.Time
+	ID        uint
+}
+
+func (c FeedCursor) Encode() string {
+	raw := fmt.Sprintf("%d:%d", c.CreatedAt.UnixNano(), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodeFeedCursor(cursor string) (*FeedCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var nanos int64
+	var id uint
+	if _, err := fmt.Sscanf(string(raw), "%d:%d", &nanos, &id); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &FeedCursor{CreatedAt: time.Unix(0, nanos), ID: id}, nil
+}
+
+// Feed returns up to limit posts by userId and their friends, newest first,
+// starting after cursor. The returned cursor is nil on the last page.
+func Feed(db *gorm.DB, userId uint, cursor *FeedCursor, limit int) ([]FeedPost, *FeedCursor, error) {
+	friendIDs := db.Table("user_friends").Select("friend_id").Where("user_id = ?", userId)
+
+	query := db.Model(&Post{}).
+		Select(`posts.id, posts.created_at, posts.caption, posts.image_url, posts.user_id,
+			users.email AS author_email,
+			(SELECT COUNT(*) FROM post_likes
+				WHERE post_likes.post_id = posts.id AND post_likes.deleted_at IS NULL) AS like_count,
+			(SELECT COUNT(*) FROM post_comments
+				WHERE post_comments.post_id = posts.id AND post_comments.deleted_at IS NULL) AS comment_count`).
+		Joins("JOIN users ON users.id = posts.user_id").
+		Where("posts.user_id = ? OR posts.user_id IN (?)", userId, friendIDs)
+
+	if cursor != nil {
+		query = query.Where("posts.created_at < ? OR (posts.created_at = ? AND posts.id < ?)",
+			cursor.CreatedAt, cursor.CreatedAt, cursor.ID)
+	}
+
+	var posts []FeedPost
+	err := query.Order("posts.created_at DESC, posts.id DESC").
+		Limit(limit + 1).
+		Scan(&posts).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(posts) <= limit {
+		return posts, nil, nil
+	}
+
+	posts = posts[:limit]
+	last := posts[len(posts)-1]
+	return posts, &FeedCursor{CreatedAt: last.CreatedAt, ID: last.ID}, nil
+}
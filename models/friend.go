@@ -0,0 +1,173 @@
+package models
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+const (
+	FriendRequestPending   = "pending"
+	FriendRequestAccepted  = "accepted"
+	FriendRequestDeclined  = "declined"
+	FriendRequestCancelled = "cancelled"
+)
+
+var (
+	ErrSelfFriendRequest      = errors.New("cannot send a friend request to yourself")
+	ErrDuplicateFriendRequest = errors.New("a pending friend request already exists between these users")
+	ErrAlreadyFriends         = errors.New("users are already friends")
+	ErrFriendRequestNotOpen   = errors.New("friend request is no longer pending")
+	ErrUserNotFound           = errors.New("user not found")
+)
+
+type FriendRequest struct {
+	gorm.Model
+
+	SenderID   uint   `gorm:"index"`
+	ReceiverID uint   `gorm:"index"`
+	Status     string `gorm:"index"`
+}
+
+// SendFriendRequest opens a pending request from sender to receiver. Only one
+// pending request may exist between two users, whichever direction it goes.
+func SendFriendRequest(db *gorm.DB, senderId, receiverId uint) (*FriendRequest, error) {
+	if senderId == receiverId {
+		return nil, ErrSelfFriendRequest
+	}
+
+	request := &FriendRequest{
+		SenderID:   senderId,
+		ReceiverID: receiverId,
+		Status:     FriendRequestPending,
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var receiver User
+		if err := tx.First(&receiver, receiverId).Error; err != nil {
+			return ErrUserNotFound
+		}
+
+		if AreFriends(tx, senderId, receiverId) {
+			return ErrAlreadyFriends
+		}
+
+		var pending int64
+		tx.Model(&FriendRequest{}).
+			Where("status = ?", FriendRequestPending).
+			Where("(sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?)",
+				senderId, receiverId, receiverId, senderId).
+			Count(&pending)
+		if pending > 0 {
+			return ErrDuplicateFriendRequest
+		}
+
+		return tx.Create(request).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return request, nil
+}
+
+// Accept closes the request and records the friendship in both directions so
+// either user's Friends association sees the other.
+func (r *FriendRequest) Accept(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := r.close(tx, FriendRequestAccepted); err != nil {
+			return err
+		}
+
+		var sender, receiver User
+		if err := tx.First(&sender, r.SenderID).Error; err != nil {
+			return ErrUserNotFound
+		}
+		if err := tx.First(&receiver, r.ReceiverID).Error; err != nil {
+			return ErrUserNotFound
+		}
+
+		if err := tx.Model(&sender).Association("Friends").Append(&receiver); err != nil {
+			return err
+		}
+		return tx.Model(&receiver).Association("Friends").Append(&sender)
+	})
+}
+
+func (r *FriendRequest) Decline(db *gorm.DB) error {
+	return r.close(db, FriendRequestDeclined)
+}
+
+func (r *FriendRequest) Cancel(db *gorm.DB) error {
+	return r.close(db, FriendRequestCancelled)
+}
+
+// close moves a pending request to status, failing if another caller has
+// already resolved it.
+func (r *FriendRequest) close(db *gorm.DB, status string) error {
+	result := db.Model(&FriendRequest{}).
+		Where("id = ? AND status = ?", r.ID, FriendRequestPending).
+		Update("status", status)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrFriendRequestNotOpen
+	}
+
+	r.Status = status
+	return nil
+}
+
+func Unfriend(db *gorm.DB, userId, friendId uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		user := User{}
+		user.ID = userId
+		friend := User{}
+		friend.ID = friendId
+
+		if err := tx.Model(&user).Association("Friends").Delete(&friend); err != nil {
+			return err
+		}
+		return tx.Model(&friend).Association("Friends").Delete(&user)
+	})
+}
+
+func AreFriends(db *gorm.DB, userId, otherId uint) bool {
+	var count int64
+	db.Table("user_friends").
+		Where("user_id = ? AND friend_id = ?", userId, otherId).
+		Count(&count)
+
+	return count > 0
+}
+
+// FriendIDs returns the IDs of everyone userId is friends with.
+func FriendIDs(db *gorm.DB, userId uint) ([]uint, error) {
+	var ids []uint
+	err := db.Table("user_friends").
+		Where("user_id = ?", userId).
+		Pluck("friend_id", &ids).Error
+
+	return ids, err
+}
+
+func ListFriends(db *gorm.DB, userId uint, offset, limit int) ([]UserProfile, int64, error) {
+	query := db.Model(&User{}).
+		Joins("JOIN user_friends ON user_friends.friend_id = users.id").
+		Where("user_friends.user_id = ?", userId)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var friends []UserProfile
+	err := query.Select("users.id, users.email").
+		Order("users.email").
+		Offset(offset).
+		Limit(limit).
+		Scan(&friends).Error
+
+	return friends, total, err
+}
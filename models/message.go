@@ -0,0 +1,130 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	MessageSent      = "sent"
+	MessageDelivered = "delivered"
+	MessageRead      = "read"
+)
+
+// ChatMessage is either a direct message to ReceiverID or, when RoomID is
+// set, a message to a chat room. A direct message starts out sent, becomes
+// delivered once a frame carrying it has been written to one of the
+// receiver's connections, and read when the receiver says so. Anything still
+// sent when the receiver connects is replayed to them. Room messages are only
+// pushed to members who are online and do not track receipts.
+type ChatMessage struct {
+	gorm.Model
+
+	SenderID    uint  `gorm:"index"`
+	ReceiverID  uint  `gorm:"index"`
+	RoomID      *uint `gorm:"index"`
+	Body        string
+	Status      string `gorm:"index;default:sent"`
+	DeliveredAt *time.Time
+	ReadAt      *time.Time
+}
+
+func NewChatMessage(senderId, receiverId uint, body string) *ChatMessage {
+	return &ChatMessage{
+		SenderID:   senderId,
+		ReceiverID: receiverId,
+		Body:       body,
+		Status:     MessageSent,
+	}
+}
+
+func NewRoomMessage(senderId, roomId uint, body string) *ChatMessage {
+	return &ChatMessage{
+		SenderID: senderId,
+		RoomID:   &roomId,
+		Body:     body,
+		Status:   MessageSent,
+	}
+}
+
+// MarkDelivered moves the message from sent to delivered and reports whether
+// this call was the one that did so, so only one receipt goes out even when
+// the receiver has several connections.
+func (m *ChatMessage) MarkDelivered(db *gorm.DB) (bool, error) {
+	now := time.Now()
+	result := db.Model(&ChatMessage{}).
+		Where("id = ? AND status = ?", m.ID, MessageSent).
+		Updates(map[string]interface{}{"status": MessageDelivered, "delivered_at": now})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+
+	m.Status = MessageDelivered
+	m.DeliveredAt = &now
+	return true, nil
+}
+
+// MarkRead marks the given messages addressed to readerId as read and
+// returns the ones that changed. IDs that are not readerId's, or are already
+// read, are ignored.
+func MarkRead(db *gorm.DB, readerId uint, ids []uint) ([]ChatMessage, error) {
+	var messages []ChatMessage
+	err := db.Where("id IN ? AND receiver_id = ? AND status <> ?", ids, readerId, MessageRead).
+		Find(&messages).Error
+	if err != nil || len(messages) == 0 {
+		return nil, err
+	}
+
+	now := time.Now()
+	changed := make([]uint, len(messages))
+	for i := range messages {
+		changed[i] = messages[i].ID
+		messages[i].Status = MessageRead
+		messages[i].ReadAt = &now
+		if messages[i].DeliveredAt == nil {
+			messages[i].DeliveredAt = &now
+		}
+	}
+
+	err = db.Model(&ChatMessage{}).
+		Where("id IN ?", changed).
+		Updates(map[string]interface{}{
+			"status":       MessageRead,
+			"read_at":      now,
+			"delivered_at": gorm.Expr("COALESCE(delivered_at, ?)", now),
+		}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// UndeliveredMessages returns everything queued for receiverId, oldest first.
+func UndeliveredMessages(db *gorm.DB, receiverId uint) ([]ChatMessage, error) {
+	var messages []ChatMessage
+	err := db.Where("receiver_id = ? AND status = ?", receiverId, MessageSent).
+		Order("id").
+		Find(&messages).Error
+
+	return messages, err
+}
+
+// Conversation returns up to limit messages exchanged between two users,
+// newest first. A non-zero before only returns messages older than that ID.
+func Conversation(db *gorm.DB, userId, otherId uint, before uint, limit int) ([]ChatMessage, error) {
+	query := db.Where("(sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?)",
+		userId, otherId, otherId, userId)
+	if before != 0 {
+		query = query.Where("id < ?", before)
+	}
+
+	var messages []ChatMessage
+	err := query.Order("id DESC").Limit(limit).Find(&messages).Error
+
+	return messages, err
+}
@@ -0,0 +1,220 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	NotificationLike           = "like"
+	NotificationComment        = "comment"
+	NotificationMention        = "mention"
+	NotificationFriendRequest  = "friend_request"
+	NotificationFriendAccepted = "friend_accepted"
+)
+
+var ErrNotificationNotFound = errors.New("notification not found")
+
+// Notification tells a user that someone did something involving them.
+// While it is unread, further likes, comments or mentions on the same post
+// are folded into it rather than piling up, with ActorID left pointing at
+// whoever acted last.
+type Notification struct {
+	gorm.Model
+
+	UserID     uint        `gorm:"index" json:"user_id"`
+	Kind       string      `json:"kind"`
+	SubjectID  uint        `json:"subject_id"`
+	ActorID    uint        `json:"actor_id"`
+	ActorCount int         `json:"actor_count"`
+	ReadAt     *time.Time  `json:"read_at"`
+	Actor      UserProfile `gorm:"-" json:"actor"`
+	Summary    string      `gorm:"-" json:"summary"`
+}
+
+// NotificationActor records everyone folded into a notification, so that
+// the same person acting twice is only counted once.
+type NotificationActor struct {
+	NotificationID uint `gorm:"primaryKey"`
+	ActorID        uint `gorm:"primaryKey"`
+}
+
+// coalesces reports whether notifications of kind are folded together per
+// subject. A friend request is only ever about one person.
+func coalesces(kind string) bool {
+	return kind == NotificationLike || kind == NotificationComment || kind == NotificationMention
+}
+
+// RecordNotification notes that actorId did kind to userId's subject and
+// returns the notification as it now stands.
+func RecordNotification(db *gorm.DB, userId, actorId uint, kind string, subjectId uint) (*Notification, error) {
+	var notification Notification
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		found := false
+		if coalesces(kind) {
+			err := tx.Where("user_id = ? AND kind = ? AND subject_id = ? AND read_at IS NULL", userId, kind, subjectId).
+				First(&notification).Error
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+			found = err == nil
+		}
+
+		if !found {
+			notification = Notification{
+				UserID:    userId,
+				Kind:      kind,
+				SubjectID: subjectId,
+			}
+		}
+		notification.ActorID = actorId
+		if err := tx.Save(&notification).Error; err != nil {
+			return err
+		}
+
+		actor := NotificationActor{NotificationID: notification.ID, ActorID: actorId}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&actor).Error; err != nil {
+			return err
+		}
+
+		var count int64
+		if err := tx.Model(&NotificationActor{}).Where("notification_id = ?", notification.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		notification.ActorCount = int(count)
+		return tx.Model(&notification).Update("actor_count", notification.ActorCount).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := describeNotifications(db, []*Notification{&notification}); err != nil {
+		return nil, err
+	}
+	return &notification, nil
+}
+
+// ListNotifications returns a page of userId's notifications, most recently
+// active first, along with how many they have in total.
+func ListNotifications(db *gorm.DB, userId uint, unreadOnly bool, offset, limit int) ([]Notification, int64, error) {
+	query := db.Model(&Notification{}).Where("user_id = ?", userId)
+	if unreadOnly {
+		query = query.Where("read_at IS NULL")
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var notifications []Notification
+	err := query.Order("updated_at DESC").
+		Order("id DESC").
+		Offset(offset).
+		Limit(limit).
+		Find(&notifications).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	described := make([]*Notification, len(notifications))
+	for i := range notifications {
+		described[i] = &notifications[i]
+	}
+	return notifications, total, describeNotifications(db, described)
+}
+
+func UnreadNotificationCount(db *gorm.DB, userId uint) (int64, error) {
+	var count int64
+	err := db.Model(&Notification{}).
+		Where("user_id = ? AND read_at IS NULL", userId).
+		Count(&count).Error
+
+	return count, err
+}
+
+func MarkNotificationRead(db *gorm.DB, userId, notificationId uint) error {
+	result := db.Model(&Notification{}).
+		Where("id = ? AND user_id = ?", notificationId, userId).
+		Where("read_at IS NULL").
+		Update("read_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		var count int64
+		db.Model(&Notification{}).Where("id = ? AND user_id = ?", notificationId, userId).Count(&count)
+		if count == 0 {
+			return ErrNotificationNotFound
+		}
+	}
+	return nil
+}
+
+// MarkAllNotificationsRead marks everything userId has unread as read and
+// returns how many that was.
+func MarkAllNotificationsRead(db *gorm.DB, userId uint) (int64, error) {
+	result := db.Model(&Notification{}).
+		Where("user_id = ? AND read_at IS NULL", userId).
+		Update("read_at", time.Now())
+
+	return result.RowsAffected, result.Error
+}
+
+// describeNotifications fills in the latest actor and a readable summary.
+func describeNotifications(db *gorm.DB, notifications []*Notification) error {
+	if len(notifications) == 0 {
+		return nil
+	}
+
+	actorIds := make([]uint, 0, len(notifications))
+	for _, notification := range notifications {
+		actorIds = append(actorIds, notification.ActorID)
+	}
+
+	var actors []UserProfile
+	if err := db.Model(&User{}).Select("id, email").Where("id IN ?", uniqueIDs(actorIds)).Scan(&actors).Error; err != nil {
+		return err
+	}
+	byId := make(map[uint]UserProfile, len(actors))
+	for _, actor := range actors {
+		byId[actor.ID] = actor
+	}
+
+	for _, notification := range notifications {
+		notification.Actor = byId[notification.ActorID]
+		notification.Summary = notification.describe()
+	}
+	return nil
+}
+
+func (n *Notification) describe() string {
+	who := n.Actor.Email
+	switch others := n.ActorCount - 1; {
+	case others == 1:
+		who += " and 1 other"
+	case others > 1:
+		who += fmt.Sprintf(" and %d others", others)
+	}
+
+	switch n.Kind {
+	case NotificationLike:
+		return who + " liked your post"
+	case NotificationComment:
+		return who + " commented on your post"
+	case NotificationMention:
+		return who + " mentioned you in a post"
+	case NotificationFriendRequest:
+		return who + " sent you a friend request"
+	case NotificationFriendAccepted:
+		return who + " accepted your friend request"
+	default:
+		return who
+	}
+}
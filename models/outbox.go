@@ -0,0 +1,101 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	OutboxPending = "pending"
+	OutboxSent    = "sent"
+	OutboxFailed  = "failed"
+)
+
+// OutboxEmail is a rendered email waiting to be sent. Writing it here first
+// means mail survives restarts and is retried if the transport fails.
+type OutboxEmail struct {
+	gorm.Model
+
+	To            string
+	Template      string
+	Subject       string
+	Body          string
+	Status        string    `gorm:"index;default:pending"`
+	Attempts      int       `gorm:"default:0"`
+	NextAttemptAt time.Time `gorm:"index"`
+	LastError     string
+	SentAt        *time.Time
+}
+
+func NewOutboxEmail(to, template, subject, body string) *OutboxEmail {
+	return &OutboxEmail{
+		To:            to,
+		Template:      template,
+		Subject:       subject,
+		Body:          body,
+		Status:        OutboxPending,
+		NextAttemptAt: time.Now(),
+	}
+}
+
+// DueEmails returns up to limit pending emails whose next attempt is due,
+// oldest first.
+func DueEmails(db *gorm.DB, now time.Time, limit int) ([]OutboxEmail, error) {
+	var emails []OutboxEmail
+	err := db.Where("status = ? AND next_attempt_at <= ?", OutboxPending, now).
+		Order("next_attempt_at").
+		Limit(limit).
+		Find(&emails).Error
+
+	return emails, err
+}
+
+// Claim starts an attempt at sending the email, holding it until leaseUntil
+// so that no other sender picks it up meanwhile. It reports false if another
+// sender got there first. A sender that dies mid-attempt leaves the email to
+// be picked up again once the lease runs out.
+func (e *OutboxEmail) Claim(db *gorm.DB, leaseUntil time.Time) (bool, error) {
+	result := db.Model(&OutboxEmail{}).
+		Where("id = ? AND status = ? AND attempts = ?", e.ID, OutboxPending, e.Attempts).
+		Updates(map[string]interface{}{
+			"attempts":        e.Attempts + 1,
+			"next_attempt_at": leaseUntil,
+		})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+
+	e.Attempts++
+	e.NextAttemptAt = leaseUntil
+	return true, nil
+}
+
+func (e *OutboxEmail) MarkSent(db *gorm.DB) error {
+	now := time.Now()
+	e.Status = OutboxSent
+	e.SentAt = &now
+
+	return db.Model(e).Updates(map[string]interface{}{
+		"status":     e.Status,
+		"sent_at":    e.SentAt,
+		"last_error": "",
+	}).Error
+}
+
+// MarkFailed records a failed attempt. The email is retried at retryAt, or
+// given up on for good if retryAt is nil.
+func (e *OutboxEmail) MarkFailed(db *gorm.DB, cause error, retryAt *time.Time) error {
+	e.LastError = cause.Error()
+	updates := map[string]interface{}{"last_error": e.LastError}
+
+	if retryAt == nil {
+		e.Status = OutboxFailed
+		updates["status"] = e.Status
+	} else {
+		e.NextAttemptAt = *retryAt
+		updates["next_attempt_at"] = e.NextAttemptAt
+	}
+
+	return db.Model(e).Updates(updates).Error
+}
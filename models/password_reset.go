@@ -0,0 +1,99 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/tenkorangjr/circle-app/utils"
+	"gorm.io/gorm"
+)
+
+var ErrInvalidResetToken = errors.New("invalid or expired reset token")
+
+// PasswordReset lets whoever holds its token choose a new password for a
+// user. Only a hash of the token is stored, and it works once.
+type PasswordReset struct {
+	gorm.Model
+
+	UserID    uint   `gorm:"index"`
+	TokenHash string `gorm:"uniqueIndex"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+// IssuePasswordReset creates a reset token for userId that is good for ttl,
+// replacing any unused ones issued before it.
+func IssuePasswordReset(db *gorm.DB, userId uint, ttl time.Duration) (string, error) {
+	token, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND used_at IS NULL", userId).Delete(&PasswordReset{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&PasswordReset{
+			UserID:    userId,
+			TokenHash: utils.HashToken(token),
+			ExpiresAt: time.Now().Add(ttl),
+		}).Error
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// ResetPassword uses up a reset token to give its user a new password, and
+// signs them out everywhere and revokes their access tokens so that whoever
+// knew the old one loses access.
+func ResetPassword(db *gorm.DB, token, password string) (*User, error) {
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	var user User
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var reset PasswordReset
+		err := tx.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", utils.HashToken(token), time.Now()).
+			First(&reset).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidResetToken
+		}
+		if err != nil {
+			return err
+		}
+
+		result := tx.Model(&PasswordReset{}).
+			Where("id = ? AND used_at IS NULL", reset.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidResetToken
+		}
+
+		if err := tx.First(&user, reset.UserID).Error; err != nil {
+			return err
+		}
+		user.Password = hashedPassword
+		if err := tx.Model(&user).Update("password", hashedPassword).Error; err != nil {
+			return err
+		}
+
+		if err := RevokeUserSessions(tx, user.ID); err != nil {
+			return err
+		}
+		return RevokeUserPersonalAccessTokens(tx, user.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
@@ -0,0 +1,7 @@
+package requestmodel
+
+type CreateAccessTokenRequest struct {
+	Name          string   `json:"name" validate:"required,max=100"`
+	Scopes        []string `json:"scopes" validate:"required,min=1,dive,oneof=read write"`
+	ExpiresInDays int      `json:"expires_in_days" validate:"min=0,max=365"`
+}
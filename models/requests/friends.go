@@ -0,0 +1,5 @@
+package requestmodel
+
+type FriendRequestRequest struct {
+	UserID uint `json:"user_id" validate:"required"`
+}
@@ -0,0 +1,10 @@
+package requestmodel
+
+type CreateRoomRequest struct {
+	Name      string `json:"name" validate:"required,max=100"`
+	MemberIDs []uint `json:"member_ids" validate:"max=100"`
+}
+
+type RoomMemberRequest struct {
+	UserID uint `json:"user_id" validate:"required"`
+}
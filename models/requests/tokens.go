@@ -0,0 +1,5 @@
+package requestmodel
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
@@ -0,0 +1,164 @@
+package models
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrAlreadyRoomMember = errors.New("user is already a member of this room")
+	ErrNotRoomMember     = errors.New("user is not a member of this room")
+)
+
+type ChatRoom struct {
+	gorm.Model
+
+	Name    string
+	OwnerID uint
+	Members []*User `gorm:"many2many:chat_room_members;" json:"-"`
+}
+
+// CreateRoom makes a room owned by ownerId with the owner and memberIds as
+// its initial members.
+func CreateRoom(db *gorm.DB, ownerId uint, name string, memberIds []uint) (*ChatRoom, error) {
+	room := &ChatRoom{
+		Name:    name,
+		OwnerID: ownerId,
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		ids := append([]uint{ownerId}, memberIds...)
+
+		var members []*User
+		if err := tx.Where("id IN ?", ids).Find(&members).Error; err != nil {
+			return err
+		}
+		if len(members) != len(uniqueIDs(ids)) {
+			return ErrUserNotFound
+		}
+
+		room.Members = members
+		return tx.Omit("Members.*").Create(room).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return room, nil
+}
+
+func (r *ChatRoom) AddMember(db *gorm.DB, userId uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var user User
+		if err := tx.First(&user, userId).Error; err != nil {
+			return ErrUserNotFound
+		}
+
+		if IsRoomMember(tx, r.ID, userId) {
+			return ErrAlreadyRoomMember
+		}
+
+		return tx.Model(r).Omit("Members.*").Association("Members").Append(&user)
+	})
+}
+
+// RemoveMember takes userId out of the room. If they owned it, ownership
+// passes to the remaining member with the lowest ID, and a room left with no
+// members is deleted.
+func (r *ChatRoom) RemoveMember(db *gorm.DB, userId uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if !IsRoomMember(tx, r.ID, userId) {
+			return ErrNotRoomMember
+		}
+
+		user := User{}
+		user.ID = userId
+		if err := tx.Model(r).Association("Members").Delete(&user); err != nil {
+			return err
+		}
+
+		memberIds, err := RoomMemberIDs(tx, r.ID)
+		if err != nil {
+			return err
+		}
+
+		if len(memberIds) == 0 {
+			return tx.Delete(r).Error
+		}
+
+		if r.OwnerID == userId {
+			r.OwnerID = memberIds[0]
+			return tx.Model(r).Update("owner_id", r.OwnerID).Error
+		}
+		return nil
+	})
+}
+
+func IsRoomMember(db *gorm.DB, roomId, userId uint) bool {
+	var count int64
+	db.Table("chat_room_members").
+		Where("chat_room_id = ? AND user_id = ?", roomId, userId).
+		Count(&count)
+
+	return count > 0
+}
+
+func RoomMemberIDs(db *gorm.DB, roomId uint) ([]uint, error) {
+	var ids []uint
+	err := db.Table("chat_room_members").
+		Where("chat_room_id = ?", roomId).
+		Order("user_id").
+		Pluck("user_id", &ids).Error
+
+	return ids, err
+}
+
+// RoomMembers returns the profiles of everyone in the room, by ID.
+func RoomMembers(db *gorm.DB, roomId uint) ([]UserProfile, error) {
+	var members []UserProfile
+	err := db.Model(&User{}).
+		Joins("JOIN chat_room_members ON chat_room_members.user_id = users.id").
+		Where("chat_room_members.chat_room_id = ?", roomId).
+		Select("users.id, users.email").
+		Order("users.id").
+		Scan(&members).Error
+
+	return members, err
+}
+
+func RoomsForUser(db *gorm.DB, userId uint) ([]ChatRoom, error) {
+	var rooms []ChatRoom
+	err := db.Joins("JOIN chat_room_members ON chat_room_members.chat_room_id = chat_rooms.id").
+		Where("chat_room_members.user_id = ?", userId).
+		Order("chat_rooms.id").
+		Find(&rooms).Error
+
+	return rooms, err
+}
+
+// RoomMessages returns up to limit messages sent to the room, newest first. A
+// non-zero before only returns messages older than that ID.
+func RoomMessages(db *gorm.DB, roomId uint, before uint, limit int) ([]ChatMessage, error) {
+	query := db.Where("room_id = ?", roomId)
+	if before != 0 {
+		query = query.Where("id < ?", before)
+	}
+
+	var messages []ChatMessage
+	err := query.Order("id DESC").Limit(limit).Find(&messages).Error
+
+	return messages, err
+}
+
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]struct{}, len(ids))
+	result := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			result = append(result, id)
+		}
+	}
+	return result
+}
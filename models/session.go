@@ -0,0 +1,157 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/tenkorangjr/circle-app/utils"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+// Session is a single signin. Every refresh token issued for it belongs to
+// the same family, so revoking the session invalidates all of them along with
+// any access tokens that carry its ID.
+type Session struct {
+	gorm.Model
+
+	UserID    uint `gorm:"index"`
+	RevokedAt *time.Time
+}
+
+type RefreshToken struct {
+	gorm.Model
+
+	SessionID uint   `gorm:"index"`
+	TokenHash string `gorm:"uniqueIndex"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+func NewSession(userId uint) *Session {
+	return &Session{
+		UserID: userId,
+	}
+}
+
+// Start persists the session and returns its first refresh token.
+func (s *Session) Start(db *gorm.DB) (string, error) {
+	var token string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(s).Error; err != nil {
+			return err
+		}
+
+		var err error
+		token, err = s.issueRefreshToken(tx)
+		return err
+	})
+
+	return token, err
+}
+
+func (s *Session) Revoke(db *gorm.DB) error {
+	return db.Model(&Session{}).
+		Where("id = ? AND revoked_at IS NULL", s.ID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (s *Session) issueRefreshToken(tx *gorm.DB) (string, error) {
+	token, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	refreshToken := RefreshToken{
+		SessionID: s.ID,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(utils.RefreshTokenTTL),
+	}
+	if err := tx.Create(&refreshToken).Error; err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one in the same
+// family. Presenting a token that has already been exchanged revokes the
+// whole session, since either the client or an attacker is replaying it.
+func RotateRefreshToken(db *gorm.DB, token string) (*Session, string, error) {
+	var session Session
+	var newToken string
+	var reused bool
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var refreshToken RefreshToken
+		if err := tx.Where("token_hash = ?", utils.HashToken(token)).First(&refreshToken).Error; err != nil {
+			return ErrInvalidRefreshToken
+		}
+
+		if err := tx.First(&session, refreshToken.SessionID).Error; err != nil {
+			return ErrInvalidRefreshToken
+		}
+
+		if session.RevokedAt != nil || time.Now().After(refreshToken.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+
+		result := tx.Model(&RefreshToken{}).
+			Where("id = ? AND used_at IS NULL", refreshToken.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			reused = true
+			return ErrRefreshTokenReused
+		}
+
+		var err error
+		newToken, err = session.issueRefreshToken(tx)
+		return err
+	})
+
+	if reused {
+		if err := session.Revoke(db); err != nil {
+			return nil, "", err
+		}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &session, newToken, nil
+}
+
+// RevokeSessionByRefreshToken ends the session the given refresh token
+// belongs to.
+func RevokeSessionByRefreshToken(db *gorm.DB, token string) error {
+	var refreshToken RefreshToken
+	if err := db.Where("token_hash = ?", utils.HashToken(token)).First(&refreshToken).Error; err != nil {
+		return ErrInvalidRefreshToken
+	}
+
+	session := Session{}
+	session.ID = refreshToken.SessionID
+	return session.Revoke(db)
+}
+
+func RevokeUserSessions(db *gorm.DB, userId uint) error {
+	return db.Model(&Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userId).
+		Update("revoked_at", time.Now()).Error
+}
+
+func IsSessionActive(db *gorm.DB, sessionId uint) bool {
+	var count int64
+	db.Model(&Session{}).
+		Where("id = ? AND revoked_at IS NULL", sessionId).
+		Count(&count)
+
+	return count > 0
+}
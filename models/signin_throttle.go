@@ -0,0 +1,126 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SigninPolicy decides how hard failed signins are pushed back on.
+// FreeFailures go unpunished; each one after that blocks further signins for
+// Delay, doubling every time up to MaxDelay, until LockAfter failures lock
+// signins out for LockFor. Failures older than LockFor are forgotten.
+type SigninPolicy struct {
+	FreeFailures int
+	Delay        time.Duration
+	MaxDelay     time.Duration
+	LockAfter    int
+	LockFor      time.Duration
+}
+
+var (
+	// AccountSigninPolicy applies to failures against a single email address.
+	AccountSigninPolicy = SigninPolicy{
+		FreeFailures: 3,
+		Delay:        time.Second,
+		MaxDelay:     time.Minute,
+		LockAfter:    10,
+		LockFor:      15 * time.Minute,
+	}
+	// IPSigninPolicy applies to failures from a single IP, whichever accounts
+	// they were against.
+	IPSigninPolicy = SigninPolicy{
+		FreeFailures: 20,
+		Delay:        time.Second,
+		MaxDelay:     time.Minute,
+		LockAfter:    100,
+		LockFor:      15 * time.Minute,
+	}
+)
+
+// SigninThrottle counts recent failed signins for a key, either an account
+// or an IP, and when signins for it may next be attempted.
+type SigninThrottle struct {
+	gorm.Model
+
+	Key           string `gorm:"uniqueIndex"`
+	Failures      int
+	LastFailureAt time.Time
+	BlockedUntil  *time.Time
+}
+
+func AccountSigninKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func IPSigninKey(ip string) string {
+	return "ip:" + ip
+}
+
+// SigninBlockedFor returns how much longer signins are blocked for, the
+// longest of any of keys, or zero if they aren't.
+func SigninBlockedFor(db *gorm.DB, now time.Time, keys ...string) (time.Duration, error) {
+	var throttles []SigninThrottle
+	if err := db.Where("key IN ? AND blocked_until > ?", keys, now).Find(&throttles).Error; err != nil {
+		return 0, err
+	}
+
+	var wait time.Duration
+	for _, throttle := range throttles {
+		wait = max(wait, throttle.BlockedUntil.Sub(now))
+	}
+	return wait, nil
+}
+
+// RecordSigninFailure counts a failed signin against key and blocks it for
+// as long as policy says. It reports whether this failure locked key out.
+func RecordSigninFailure(db *gorm.DB, key string, policy SigninPolicy, now time.Time) (bool, error) {
+	locked := false
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var throttle SigninThrottle
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).First(&throttle).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		throttle.Key = key
+		if now.Sub(throttle.LastFailureAt) > policy.LockFor {
+			throttle.Failures = 0
+		}
+		throttle.Failures++
+		throttle.LastFailureAt = now
+		throttle.BlockedUntil = nil
+
+		switch {
+		case throttle.Failures == policy.LockAfter:
+			locked = true
+			fallthrough
+		case throttle.Failures > policy.LockAfter:
+			until := now.Add(policy.LockFor)
+			throttle.BlockedUntil = &until
+		case throttle.Failures > policy.FreeFailures:
+			until := now.Add(policy.delay(throttle.Failures - policy.FreeFailures))
+			throttle.BlockedUntil = &until
+		}
+
+		return tx.Save(&throttle).Error
+	})
+
+	return locked, err
+}
+
+// ClearSigninFailures forgets the failures counted against key.
+func ClearSigninFailures(db *gorm.DB, key string) error {
+	return db.Unscoped().Where("key = ?", key).Delete(&SigninThrottle{}).Error
+}
+
+// delay returns how long to block for after the nth punished failure.
+func (p SigninPolicy) delay(n int) time.Duration {
+	delay := float64(p.Delay) * math.Pow(2, float64(n-1))
+	return time.Duration(min(delay, float64(p.MaxDelay)))
+}
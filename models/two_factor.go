@@ -0,0 +1,360 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/tenkorangjr/circle-app/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	recoveryCodeCount          = 10
+	maxTwoFactorChallengeTries = 5
+	twoFactorIssuer            = "Circle"
+)
+
+var (
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotPending     = errors.New("start two-factor enrollment first")
+	ErrTwoFactorNotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrInvalidTwoFactorCode    = errors.New("invalid two-factor code")
+	ErrInvalidChallenge        = errors.New("invalid or expired signin challenge")
+)
+
+// TwoFactor holds a user's TOTP secret. It only protects the account once
+// confirmed, which proves the user's authenticator app has the secret.
+type TwoFactor struct {
+	gorm.Model
+
+	UserID      uint `gorm:"uniqueIndex"`
+	Secret      string
+	ConfirmedAt *time.Time
+	// the time step of the last code accepted, so that no code works twice
+	LastUsedStep int64
+}
+
+// RecoveryCode gets a user past two-factor authentication once, for when
+// their authenticator app is lost. Only a hash of it is stored.
+type RecoveryCode struct {
+	gorm.Model
+
+	UserID   uint `gorm:"index"`
+	CodeHash string
+	UsedAt   *time.Time
+}
+
+// TwoFactorChallenge is handed out by a signin with the right password and
+// must be exchanged along with a valid code before the signin completes.
+type TwoFactorChallenge struct {
+	gorm.Model
+
+	UserID    uint   `gorm:"index"`
+	TokenHash string `gorm:"uniqueIndex"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	Attempts  int
+}
+
+// BeginTwoFactorEnrollment gives user a fresh secret to add to their
+// authenticator app, replacing any unconfirmed one. It returns the secret
+// and the otpauth URI for it.
+func BeginTwoFactorEnrollment(db *gorm.DB, user *User) (string, string, error) {
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var existing TwoFactor
+		err := tx.Where("user_id = ?", user.ID).First(&existing).Error
+		if err == nil && existing.ConfirmedAt != nil {
+			return ErrTwoFactorAlreadyEnabled
+		}
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		existing.UserID = user.ID
+		existing.Secret = secret
+		existing.LastUsedStep = 0
+		return tx.Save(&existing).Error
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return secret, utils.TOTPURI(twoFactorIssuer, user.Email, secret), nil
+}
+
+// ConfirmTwoFactor turns on two-factor authentication for userId once they
+// prove their app works with a first code, and returns their recovery codes.
+// The codes are only ever available here.
+func ConfirmTwoFactor(db *gorm.DB, userId uint, code string) ([]string, error) {
+	var codes []string
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var twoFactor TwoFactor
+		err := tx.Where("user_id = ? AND confirmed_at IS NULL", userId).First(&twoFactor).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			var count int64
+			tx.Model(&TwoFactor{}).Where("user_id = ?", userId).Count(&count)
+			if count > 0 {
+				return ErrTwoFactorAlreadyEnabled
+			}
+			return ErrTwoFactorNotPending
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := twoFactor.useCode(tx, code); err != nil {
+			return err
+		}
+		if err := tx.Model(&twoFactor).Update("confirmed_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		codes, err = replaceRecoveryCodes(tx, userId)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// DisableTwoFactor turns two-factor authentication off for userId, given a
+// current code or a recovery code.
+func DisableTwoFactor(db *gorm.DB, userId uint, code string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		twoFactor, err := confirmedTwoFactor(tx, userId)
+		if err != nil {
+			return err
+		}
+
+		if err := twoFactor.verify(tx, code); err != nil {
+			return err
+		}
+
+		if err := tx.Unscoped().Delete(twoFactor).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userId).Delete(&RecoveryCode{}).Error
+	})
+}
+
+func TwoFactorEnabled(db *gorm.DB, userId uint) bool {
+	var count int64
+	db.Model(&TwoFactor{}).
+		Where("user_id = ? AND confirmed_at IS NOT NULL", userId).
+		Count(&count)
+
+	return count > 0
+}
+
+// IssueTwoFactorChallenge starts the second step of a signin for userId.
+func IssueTwoFactorChallenge(db *gorm.DB, userId uint, ttl time.Duration) (string, error) {
+	token, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	challenge := TwoFactorChallenge{
+		UserID:    userId,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := db.Create(&challenge).Error; err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// TwoFactorChallengeUser returns the user an outstanding signin challenge
+// is for.
+func TwoFactorChallengeUser(db *gorm.DB, token string) (*User, error) {
+	challenge, err := outstandingChallenge(db, token)
+	if err != nil {
+		return nil, err
+	}
+
+	var user User
+	if err := db.First(&user, challenge.UserID).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// CompleteTwoFactorChallenge checks code, either from the authenticator app
+// or a recovery code, against a signin challenge and returns the user it
+// was for. A challenge can be completed once, and is given up on after a
+// few wrong codes.
+func CompleteTwoFactorChallenge(db *gorm.DB, token, code string) (*User, error) {
+	var user User
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		challenge, err := outstandingChallenge(tx, token)
+		if err != nil {
+			return err
+		}
+
+		twoFactor, err := confirmedTwoFactor(tx, challenge.UserID)
+		if err != nil {
+			return err
+		}
+		if err := twoFactor.verify(tx, code); err != nil {
+			return err
+		}
+
+		result := tx.Model(&TwoFactorChallenge{}).
+			Where("id = ? AND used_at IS NULL", challenge.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidChallenge
+		}
+
+		return tx.First(&user, challenge.UserID).Error
+	})
+	if errors.Is(err, ErrInvalidTwoFactorCode) {
+		// counted outside the transaction, which has been rolled back
+		db.Model(&TwoFactorChallenge{}).
+			Where("token_hash = ?", utils.HashToken(token)).
+			Update("attempts", gorm.Expr("attempts + 1"))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// outstandingChallenge finds a challenge that can still be completed.
+func outstandingChallenge(db *gorm.DB, token string) (*TwoFactorChallenge, error) {
+	var challenge TwoFactorChallenge
+	err := db.Where("token_hash = ? AND used_at IS NULL AND expires_at > ? AND attempts < ?",
+		utils.HashToken(token), time.Now(), maxTwoFactorChallengeTries).
+		First(&challenge).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidChallenge
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &challenge, nil
+}
+
+func confirmedTwoFactor(tx *gorm.DB, userId uint) (*TwoFactor, error) {
+	var twoFactor TwoFactor
+	err := tx.Where("user_id = ? AND confirmed_at IS NOT NULL", userId).First(&twoFactor).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrTwoFactorNotEnabled
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &twoFactor, nil
+}
+
+// verify accepts either a code from the authenticator app or an unused
+// recovery code.
+func (t *TwoFactor) verify(tx *gorm.DB, code string) error {
+	err := t.useCode(tx, code)
+	if !errors.Is(err, ErrInvalidTwoFactorCode) {
+		return err
+	}
+
+	// the codes are salted, so each unused one has to be checked in turn
+	var recoveryCodes []RecoveryCode
+	if err := tx.Where("user_id = ? AND used_at IS NULL", t.UserID).Find(&recoveryCodes).Error; err != nil {
+		return err
+	}
+
+	normalized := normalizeRecoveryCode(code)
+	for _, recoveryCode := range recoveryCodes {
+		if !utils.ValidatePassword(recoveryCode.CodeHash, normalized) {
+			continue
+		}
+
+		result := tx.Model(&RecoveryCode{}).
+			Where("id = ? AND used_at IS NULL", recoveryCode.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidTwoFactorCode
+		}
+		return nil
+	}
+
+	return ErrInvalidTwoFactorCode
+}
+
+// useCode accepts a code from the authenticator app, unless it or a later
+// one has already been used.
+func (t *TwoFactor) useCode(tx *gorm.DB, code string) error {
+	step, ok := utils.ValidateTOTP(t.Secret, code, time.Now())
+	if !ok {
+		return ErrInvalidTwoFactorCode
+	}
+
+	result := tx.Model(&TwoFactor{}).
+		Where("id = ? AND last_used_step < ?", t.ID, step).
+		Update("last_used_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+
+	t.LastUsedStep = step
+	return nil
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userId uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userId).Delete(&RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		codes[i] = code[:4] + "-" + code[4:]
+
+		// bcrypt rather than a fast hash, since a code is too short to
+		// survive an offline search if the table leaks
+		codeHash, err := utils.HashPassword(normalizeRecoveryCode(codes[i]))
+		if err != nil {
+			return nil, err
+		}
+
+		recoveryCode := RecoveryCode{UserID: userId, CodeHash: codeHash}
+		if err := tx.Create(&recoveryCode).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, nil
+}
+
+// normalizeRecoveryCode ignores case and dashes so that codes can be typed
+// back however is convenient.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"time"
+
 	"github.com/tenkorangjr/circle-app/utils"
 	"gorm.io/gorm"
 )
@@ -8,9 +10,17 @@ import (
 type User struct {
 	gorm.Model
 
-	Email    string  `binding:"required" validate:"required,email"`
-	Password string  `binding:"required"`
-	Friends  []*User `gorm:"many2many:user_friends;"`
+	Email           string  `binding:"required" validate:"required,email"`
+	Password        string  `binding:"required"`
+	Friends         []*User `gorm:"many2many:user_friends;"`
+	LastSeenAt      *time.Time
+	EmailVerifiedAt *time.Time
+}
+
+// UserProfile is the part of a User that is safe to show to other users.
+type UserProfile struct {
+	ID    uint   `json:"id"`
+	Email string `json:"email"`
 }
 
 func NewUser(email, password string) *User {
@@ -31,3 +41,25 @@ func (u *User) Save(db *gorm.DB) error {
 
 	return result.Error
 }
+
+// TouchLastSeen records that the user was connected just now.
+func TouchLastSeen(db *gorm.DB, userId uint) (time.Time, error) {
+	now := time.Now()
+	err := db.Model(&User{}).Where("id = ?", userId).Update("last_seen_at", now).Error
+
+	return now, err
+}
+
+// HasVerifiedEmailIn reports whether the user has verified one of emails.
+func HasVerifiedEmailIn(db *gorm.DB, userId uint, emails []string) bool {
+	if len(emails) == 0 {
+		return false
+	}
+
+	var count int64
+	db.Model(&User{}).
+		Where("id = ? AND email IN ? AND email_verified_at IS NOT NULL", userId, emails).
+		Count(&count)
+
+	return count > 0
+}
@@ -0,0 +1,119 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/tenkorangjr/circle-app/utils"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidVerificationToken = errors.New("invalid or expired verification link")
+	ErrEmailAlreadyVerified     = errors.New("email address is already verified")
+)
+
+// EmailVerification is a link sent to a user to prove they own their email
+// address. Only a hash of its token is stored, and it works once.
+type EmailVerification struct {
+	gorm.Model
+
+	UserID    uint   `gorm:"index"`
+	TokenHash string `gorm:"uniqueIndex"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+// IssueEmailVerification creates a verification token for userId that is
+// good for ttl, replacing any unused ones sent before it.
+func IssueEmailVerification(db *gorm.DB, userId uint, ttl time.Duration) (string, error) {
+	token, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND used_at IS NULL", userId).Delete(&EmailVerification{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&EmailVerification{
+			UserID:    userId,
+			TokenHash: utils.HashToken(token),
+			ExpiresAt: time.Now().Add(ttl),
+		}).Error
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// LastVerificationSentAt returns when userId was last sent a verification
+// link, or the zero time if never.
+func LastVerificationSentAt(db *gorm.DB, userId uint) (time.Time, error) {
+	var verification EmailVerification
+	err := db.Unscoped().
+		Where("user_id = ?", userId).
+		Order("created_at DESC").
+		First(&verification).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return time.Time{}, nil
+	}
+
+	return verification.CreatedAt, err
+}
+
+// VerifyEmail uses up a verification token and marks its user's email
+// address verified.
+func VerifyEmail(db *gorm.DB, token string) (*User, error) {
+	var user User
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var verification EmailVerification
+		err := tx.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", utils.HashToken(token), time.Now()).
+			First(&verification).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidVerificationToken
+		}
+		if err != nil {
+			return err
+		}
+
+		// the used_at check makes sure a race between two clicks has one winner
+		now := time.Now()
+		result := tx.Model(&EmailVerification{}).
+			Where("id = ? AND used_at IS NULL", verification.ID).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidVerificationToken
+		}
+
+		if err := tx.First(&user, verification.UserID).Error; err != nil {
+			return err
+		}
+		if user.EmailVerifiedAt == nil {
+			user.EmailVerifiedAt = &now
+			return tx.Model(&user).Update("email_verified_at", now).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func IsEmailVerified(db *gorm.DB, userId uint) bool {
+	var count int64
+	db.Model(&User{}).
+		Where("id = ? AND email_verified_at IS NOT NULL", userId).
+		Count(&count)
+
+	return count > 0
+}
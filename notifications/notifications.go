@@ -0,0 +1,59 @@
+package notifications
+
+import (
+	"regexp"
+
+	"github.com/tenkorangjr/circle-app/db"
+	"github.com/tenkorangjr/circle-app/models"
+	"github.com/tenkorangjr/circle-app/routes/websockets"
+	"go.uber.org/zap"
+)
+
+// a mention is @ followed by the user's email, e.g. "@ana@circle.app"
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w.])@([\w.%+-]+@[\w-]+(?:\.[\w-]+)+)`)
+
+// Notify records that actorId did kind to userId's subject and pushes the
+// result to userId's open connections. Nobody is notified of their own
+// actions. Failures are logged rather than returned, as a missed
+// notification is never worth failing the action that caused it.
+func Notify(kind string, userId, actorId, subjectId uint) {
+	if userId == actorId {
+		return
+	}
+
+	notification, err := models.RecordNotification(db.DB, userId, actorId, kind, subjectId)
+	if err != nil {
+		zap.S().Errorf("failed to record %s notification for user %d: %v", kind, userId, err)
+		return
+	}
+
+	unread, err := models.UnreadNotificationCount(db.DB, userId)
+	if err != nil {
+		zap.S().Errorf("failed to count notifications for user %d: %v", userId, err)
+		return
+	}
+
+	websockets.PushNotification(userId, notification, unread)
+}
+
+// NotifyMentions notifies everyone mentioned in text that actorId mentioned
+// them on postId.
+func NotifyMentions(text string, actorId, postId uint) {
+	var emails []string
+	for _, match := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		emails = append(emails, match[1])
+	}
+	if len(emails) == 0 {
+		return
+	}
+
+	var userIds []uint
+	if err := db.DB.Model(&models.User{}).Where("email IN ?", emails).Pluck("id", &userIds).Error; err != nil {
+		zap.S().Errorf("failed to look up mentioned users: %v", err)
+		return
+	}
+
+	for _, userId := range userIds {
+		Notify(models.NotificationMention, userId, actorId, postId)
+	}
+}
@@ -0,0 +1,83 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tenkorangjr/circle-app/db"
+	"github.com/tenkorangjr/circle-app/models"
+	requestmodel "github.com/tenkorangjr/circle-app/models/requests"
+	"go.uber.org/zap"
+)
+
+func createAccessToken(gc *gin.Context) {
+	var request requestmodel.CreateAccessTokenRequest
+	if err := gc.ShouldBindJSON(&request); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": "invalid access token request"})
+		return
+	}
+	if err := validate.Struct(request); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": "bad input", "err": err.Error()})
+		return
+	}
+
+	var expiresAt *time.Time
+	if request.ExpiresInDays > 0 {
+		expiry := time.Now().AddDate(0, 0, request.ExpiresInDays)
+		expiresAt = &expiry
+	}
+
+	userId := gc.GetUint("userId")
+	accessToken, token, err := models.CreatePersonalAccessToken(db.DB, userId, request.Name, request.Scopes, expiresAt)
+	if err != nil {
+		zap.S().Error("Failed to create personal access token", zap.Error(err))
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "failed to create access token"})
+		return
+	}
+
+	recordAuditEvent(gc, userId, models.AuditAccessTokenCreated)
+
+	gc.JSON(http.StatusCreated, gin.H{
+		"message":      "access token created, copy it now as it won't be shown again",
+		"token":        token,
+		"access_token": accessToken,
+	})
+}
+
+func getAccessTokens(gc *gin.Context) {
+	accessTokens, err := models.ListPersonalAccessTokens(db.DB, gc.GetUint("userId"))
+	if err != nil {
+		zap.S().Error("Failed to list personal access tokens", zap.Error(err))
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "failed to list access tokens"})
+		return
+	}
+
+	gc.JSON(http.StatusOK, gin.H{"access_tokens": accessTokens})
+}
+
+func revokeAccessToken(gc *gin.Context) {
+	accessTokenId, err := strconv.Atoi(gc.Param("tokenid"))
+	if err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": "invalid access token id format"})
+		return
+	}
+
+	userId := gc.GetUint("userId")
+	err = models.RevokePersonalAccessToken(db.DB, userId, uint(accessTokenId))
+	if errors.Is(err, models.ErrAccessTokenNotFound) {
+		gc.JSON(http.StatusNotFound, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		zap.S().Error("Failed to revoke personal access token", zap.Error(err))
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "failed to revoke access token"})
+		return
+	}
+
+	recordAuditEvent(gc, userId, models.AuditAccessTokenRevoked)
+
+	gc.JSON(http.StatusOK, gin.H{"message": "access token revoked"})
+}
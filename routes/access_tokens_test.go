@@ -0,0 +1,93 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/tenkorangjr/circle-app/db"
+	"github.com/tenkorangjr/circle-app/models"
+)
+
+type createdAccessToken struct {
+	Token       string                     `json:"token"`
+	AccessToken models.PersonalAccessToken `json:"access_token"`
+}
+
+func CreateAccessToken(t *testing.T, server *gin.Engine, jwt string, body gin.H) createdAccessToken {
+	response := AuthenticatedRequest(server, "POST", "/tokens", jwt, body)
+	assert.Equal(t, http.StatusCreated, response.Code)
+
+	var created createdAccessToken
+	json.Unmarshal(response.Body.Bytes(), &created)
+	return created
+}
+
+func TestPersonalAccessTokens(t *testing.T) {
+	db.DB = SetupTestDB()
+	server := gin.Default()
+	RegisterRoutes(server)
+
+	_, jwt := SignUpAndSignInAs(server, "alice@circle.app")
+
+	readOnly := CreateAccessToken(t, server, jwt, gin.H{"name": "feed reader", "scopes": []string{"read"}})
+	assert.True(t, strings.HasPrefix(readOnly.Token, models.PersonalAccessTokenPrefix))
+	assert.True(t, strings.HasPrefix(readOnly.Token, readOnly.AccessToken.Hint))
+
+	badScope := AuthenticatedRequest(server, "POST", "/tokens", jwt, gin.H{"name": "admin", "scopes": []string{"admin"}})
+	assert.Equal(t, http.StatusBadRequest, badScope.Code)
+
+	feedResponse := AuthenticatedRequest(server, "GET", "/feed", readOnly.Token, nil)
+	assert.Equal(t, http.StatusOK, feedResponse.Code)
+
+	var used models.PersonalAccessToken
+	db.DB.First(&used, readOnly.AccessToken.ID)
+	assert.NotNil(t, used.LastUsedAt)
+
+	writeResponse := AuthenticatedRequest(server, "POST", "/notifications/read", readOnly.Token, nil)
+	assert.Equal(t, http.StatusForbidden, writeResponse.Code)
+
+	// the chat websocket sends messages, so reading isn't enough to open it
+	chatResponse := AuthenticatedRequest(server, "GET", "/chat", readOnly.Token, nil)
+	assert.Equal(t, http.StatusForbidden, chatResponse.Code)
+
+	// tokens are only taken from the header, never the URL
+	queryResponse := AuthenticatedRequest(server, "GET", "/feed?token="+readOnly.Token, "", nil)
+	assert.Equal(t, http.StatusUnauthorized, queryResponse.Code)
+
+	// tokens can't be used to manage tokens
+	listResponse := AuthenticatedRequest(server, "GET", "/tokens", readOnly.Token, nil)
+	assert.Equal(t, http.StatusForbidden, listResponse.Code)
+
+	writer := CreateAccessToken(t, server, jwt, gin.H{"name": "poster", "scopes": []string{"write"}, "expires_in_days": 30})
+	assert.NotNil(t, writer.AccessToken.ExpiresAt)
+	writeResponse = AuthenticatedRequest(server, "POST", "/notifications/read", writer.Token, nil)
+	assert.Equal(t, http.StatusOK, writeResponse.Code)
+
+	listResponse = AuthenticatedRequest(server, "GET", "/tokens", jwt, nil)
+	assert.Equal(t, http.StatusOK, listResponse.Code)
+	assert.NotContains(t, listResponse.Body.String(), readOnly.Token)
+	var list struct {
+		AccessTokens []models.PersonalAccessToken `json:"access_tokens"`
+	}
+	json.Unmarshal(listResponse.Body.Bytes(), &list)
+	assert.Len(t, list.AccessTokens, 2)
+
+	db.DB.Model(&models.PersonalAccessToken{}).Where("id = ?", writer.AccessToken.ID).Update("expires_at", time.Now().Add(-time.Minute))
+	expiredResponse := AuthenticatedRequest(server, "GET", "/feed", writer.Token, nil)
+	assert.Equal(t, http.StatusUnauthorized, expiredResponse.Code)
+
+	revokePath := fmt.Sprintf("/tokens/%d", readOnly.AccessToken.ID)
+	revokeResponse := AuthenticatedRequest(server, "DELETE", revokePath, jwt, nil)
+	assert.Equal(t, http.StatusOK, revokeResponse.Code)
+	revokeResponse = AuthenticatedRequest(server, "DELETE", revokePath, jwt, nil)
+	assert.Equal(t, http.StatusNotFound, revokeResponse.Code)
+
+	feedResponse = AuthenticatedRequest(server, "GET", "/feed", readOnly.Token, nil)
+	assert.Equal(t, http.StatusUnauthorized, feedResponse.Code)
+}
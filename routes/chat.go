@@ -0,0 +1,83 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tenkorangjr/circle-app/db"
+	"github.com/tenkorangjr/circle-app/models"
+	"github.com/tenkorangjr/circle-app/routes/websockets"
+	"go.uber.org/zap"
+)
+
+func getConversation(gc *gin.Context) {
+	userId := gc.GetUint("userId")
+
+	otherId, err := strconv.Atoi(gc.Param("userid"))
+	if err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": "invalid user id format"})
+		return
+	}
+
+	var before int
+	if raw := gc.Query("before"); raw != "" {
+		before, err = strconv.Atoi(raw)
+		if err != nil || before < 1 {
+			gc.JSON(http.StatusBadRequest, gin.H{"message": "invalid before cursor"})
+			return
+		}
+	}
+
+	_, limit := parsePagination(gc)
+	messages, err := models.Conversation(db.DB, userId, uint(otherId), uint(before), limit)
+	if err != nil {
+		zap.S().Error("Failed to load conversation", zap.Error(err))
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "failed to load conversation"})
+		return
+	}
+
+	// Pass the oldest ID back as ?before= to page further into the history
+	var nextBefore uint
+	if len(messages) == limit {
+		nextBefore = messages[len(messages)-1].ID
+	}
+
+	gc.JSON(http.StatusOK, gin.H{"messages": messages, "next_before": nextBefore})
+}
+
+// sendChatMessage lets clients that can only read /events send messages
+// without a websocket.
+func sendChatMessage(gc *gin.Context) {
+	var payload websockets.SendMessagePayload
+	if err := gc.ShouldBindJSON(&payload); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": "incorrect fields"})
+		return
+	}
+	if err := validate.Struct(payload); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": "bad input", "err": err.Error()})
+		return
+	}
+
+	chatMessage, queued, err := websockets.SendMessage(gc.GetUint("userId"), payload)
+	if errors.Is(err, websockets.ErrInvalidAddress) {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if errors.Is(err, websockets.ErrUnknownRecipient) {
+		gc.JSON(http.StatusNotFound, gin.H{"message": "recipient not found"})
+		return
+	}
+	if errors.Is(err, websockets.ErrNotRoomMember) {
+		gc.JSON(http.StatusForbidden, gin.H{"message": "you are not a member of this room"})
+		return
+	}
+	if err != nil {
+		zap.S().Error("Failed to send message", zap.Error(err))
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "failed to send message"})
+		return
+	}
+
+	gc.JSON(http.StatusCreated, gin.H{"message": "message sent", "chat_message": chatMessage, "queued": queued})
+}
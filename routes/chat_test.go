@@ -0,0 +1,300 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/tenkorangjr/circle-app/db"
+	"github.com/tenkorangjr/circle-app/models"
+	"github.com/tenkorangjr/circle-app/routes/websockets"
+)
+
+func DialChat(t *testing.T, testServer *httptest.Server, token string) *websocket.Conn {
+	wsURL := "ws" + strings.TrimPrefix(testServer.URL, "http") + "/chat?token=" + token
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("failed to dial chat: %v", err)
+	}
+	return conn
+}
+
+func SendChatMessage(conn *websocket.Conn, id, to, body string) {
+	conn.WriteJSON(gin.H{"v": websockets.ProtocolVersion, "type": "message", "id": id, "data": gin.H{"to": to, "body": body}})
+}
+
+// ReadFrame reads the next frame off the socket, decoding its data into data
+// when given.
+func ReadFrame(t *testing.T, conn *websocket.Conn, data interface{}) websockets.Envelope {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	var envelope websockets.Envelope
+	if err := conn.ReadJSON(&envelope); err != nil {
+		t.Fatalf("failed to read frame: %v", err)
+	}
+	if data != nil {
+		json.Unmarshal(envelope.Data, data)
+	}
+	return envelope
+}
+
+func TestChatQueuesMessagesForOfflineReceiver(t *testing.T) {
+	db.DB = SetupTestDB()
+	server := gin.Default()
+	RegisterRoutes(server)
+	testServer := httptest.NewServer(server)
+	defer testServer.Close()
+
+	aliceID, aliceToken := SignUpAndSignInAs(server, "alice@circle.app")
+	bobID, bobToken := SignUpAndSignInAs(server, "bob@circle.app")
+
+	aliceConn := DialChat(t, testServer, aliceToken)
+	defer aliceConn.Close()
+	SendChatMessage(aliceConn, "c1", "bob@circle.app", "hi bob")
+
+	var ack websockets.AckPayload
+	ackFrame := ReadFrame(t, aliceConn, &ack)
+	assert.Equal(t, "ack", ackFrame.Type)
+	assert.Equal(t, "c1", ackFrame.ID)
+	assert.Equal(t, models.MessageSent, ack.Status)
+	assert.True(t, ack.Queued)
+
+	bobConn := DialChat(t, testServer, bobToken)
+	defer bobConn.Close()
+
+	var received websockets.MessagePayload
+	messageFrame := ReadFrame(t, bobConn, &received)
+	assert.Equal(t, "message", messageFrame.Type)
+	assert.Equal(t, ack.MessageID, received.ID)
+	assert.Equal(t, "hi bob", received.Body)
+	assert.Equal(t, aliceID, received.From)
+
+	// delivery is recorded just after the frame is written
+	var history struct {
+		Messages []models.ChatMessage `json:"messages"`
+	}
+	assert.Eventually(t, func() bool {
+		historyResponse := AuthenticatedRequest(server, "GET", fmt.Sprintf("/chat/%d/messages", aliceID), bobToken, nil)
+		json.Unmarshal(historyResponse.Body.Bytes(), &history)
+		return historyResponse.Code == http.StatusOK && len(history.Messages) == 1 &&
+			history.Messages[0].Status == models.MessageDelivered
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Len(t, history.Messages, 1)
+	assert.Equal(t, bobID, history.Messages[0].ReceiverID)
+	assert.NotNil(t, history.Messages[0].DeliveredAt)
+}
+
+func TestChatSendsDeliveryAndReadReceipts(t *testing.T) {
+	db.DB = SetupTestDB()
+	server := gin.Default()
+	RegisterRoutes(server)
+	testServer := httptest.NewServer(server)
+	defer testServer.Close()
+
+	aliceID, aliceToken := SignUpAndSignInAs(server, "alice@circle.app")
+	bobID, bobToken := SignUpAndSignInAs(server, "bob@circle.app")
+
+	aliceConn := DialChat(t, testServer, aliceToken)
+	defer aliceConn.Close()
+	bobConn := DialChat(t, testServer, bobToken)
+	defer bobConn.Close()
+
+	assert.Eventually(t, func() bool {
+		return websockets.WSManager.IsOnline(bobID)
+	}, time.Second, 10*time.Millisecond)
+
+	SendChatMessage(aliceConn, "c1", "bob@circle.app", "hi bob")
+
+	var received websockets.MessagePayload
+	ReadFrame(t, bobConn, &received)
+
+	// the ack and the delivery receipt race each other
+	var receipt websockets.ReceiptPayload
+	for i := 0; i < 2; i++ {
+		frame := ReadFrame(t, aliceConn, nil)
+		if frame.Type == "receipt" {
+			json.Unmarshal(frame.Data, &receipt)
+		}
+	}
+	assert.Equal(t, received.ID, receipt.MessageID)
+	assert.Equal(t, models.MessageDelivered, receipt.Status)
+
+	bobConn.WriteJSON(gin.H{"v": websockets.ProtocolVersion, "type": "read", "id": "r1", "data": gin.H{"message_ids": []uint{received.ID}}})
+	ReadFrame(t, aliceConn, &receipt)
+	assert.Equal(t, models.MessageRead, receipt.Status)
+
+	var history struct {
+		Messages []models.ChatMessage `json:"messages"`
+	}
+	historyResponse := AuthenticatedRequest(server, "GET", fmt.Sprintf("/chat/%d/messages", bobID), aliceToken, nil)
+	json.Unmarshal(historyResponse.Body.Bytes(), &history)
+	assert.Equal(t, models.MessageRead, history.Messages[0].Status)
+	assert.NotNil(t, history.Messages[0].ReadAt)
+	assert.Equal(t, aliceID, history.Messages[0].SenderID)
+}
+
+func TestChatFansOutToEveryConnection(t *testing.T) {
+	db.DB = SetupTestDB()
+	server := gin.Default()
+	RegisterRoutes(server)
+	testServer := httptest.NewServer(server)
+	defer testServer.Close()
+
+	_, aliceToken := SignUpAndSignInAs(server, "alice@circle.app")
+	bobID, bobToken := SignUpAndSignInAs(server, "bob@circle.app")
+
+	bobPhone := DialChat(t, testServer, bobToken)
+	defer bobPhone.Close()
+	bobLaptop := DialChat(t, testServer, bobToken)
+	defer bobLaptop.Close()
+
+	assert.Eventually(t, func() bool {
+		return len(websockets.WSManager.GetClients(bobID)) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	aliceConn := DialChat(t, testServer, aliceToken)
+	defer aliceConn.Close()
+	SendChatMessage(aliceConn, "c1", "bob@circle.app", "hi bob")
+
+	for _, conn := range []*websocket.Conn{bobPhone, bobLaptop} {
+		var received websockets.MessagePayload
+		ReadFrame(t, conn, &received)
+		assert.Equal(t, "hi bob", received.Body)
+	}
+}
+
+func TestChatRejectsInvalidFrames(t *testing.T) {
+	db.DB = SetupTestDB()
+	server := gin.Default()
+	RegisterRoutes(server)
+	testServer := httptest.NewServer(server)
+	defer testServer.Close()
+
+	_, aliceToken := SignUpAndSignInAs(server, "alice@circle.app")
+	aliceConn := DialChat(t, testServer, aliceToken)
+	defer aliceConn.Close()
+
+	var errorPayload websockets.ErrorPayload
+
+	SendChatMessage(aliceConn, "c1", "nobody@circle.app", "hello?")
+	errorFrame := ReadFrame(t, aliceConn, &errorPayload)
+	assert.Equal(t, "error", errorFrame.Type)
+	assert.Equal(t, "c1", errorFrame.ID)
+	assert.Equal(t, websockets.ErrCodeUnknownRecipient, errorPayload.Code)
+
+	SendChatMessage(aliceConn, "c2", "not-an-email", "hello?")
+	ReadFrame(t, aliceConn, &errorPayload)
+	assert.Equal(t, websockets.ErrCodeValidation, errorPayload.Code)
+
+	aliceConn.WriteJSON(gin.H{"v": 99, "type": "ping", "id": "c3"})
+	ReadFrame(t, aliceConn, &errorPayload)
+	assert.Equal(t, websockets.ErrCodeUnsupportedVersion, errorPayload.Code)
+
+	aliceConn.WriteJSON(gin.H{"v": websockets.ProtocolVersion, "type": "ping", "id": "c4"})
+	pongFrame := ReadFrame(t, aliceConn, nil)
+	assert.Equal(t, "pong", pongFrame.Type)
+	assert.Equal(t, "c4", pongFrame.ID)
+}
+
+func TestChatDropsPeersThatStopAnsweringPings(t *testing.T) {
+	websockets.Configure(websockets.Config{
+		WriteWait:      time.Second,
+		PongWait:       100 * time.Millisecond,
+		PingPeriod:     20 * time.Millisecond,
+		MaxMessageSize: 1024,
+	})
+	defer websockets.Configure(websockets.DefaultConfig())
+
+	db.DB = SetupTestDB()
+	server := gin.Default()
+	RegisterRoutes(server)
+	testServer := httptest.NewServer(server)
+	defer testServer.Close()
+
+	aliceID, aliceToken := SignUpAndSignInAs(server, "alice@circle.app")
+
+	// gorilla only answers pings while reading, so this peer goes silent
+	aliceConn := DialChat(t, testServer, aliceToken)
+	defer aliceConn.Close()
+
+	assert.Eventually(t, func() bool {
+		return websockets.WSManager.IsOnline(aliceID)
+	}, time.Second, 5*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return !websockets.WSManager.IsOnline(aliceID)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestChatMetricsAreServed(t *testing.T) {
+	t.Setenv("OPERATOR_EMAILS", "ops@circle.app")
+	db.DB = SetupTestDB()
+	server := gin.Default()
+	RegisterRoutes(server)
+
+	_, token := SignUpAndSignInAs(server, "ops@circle.app")
+	_, userToken := SignUpAndSignInAs(server, "alice@circle.app")
+
+	// the counters are server-wide, so only operators see them
+	forbidden := AuthenticatedRequest(server, "GET", "/chat/metrics", userToken, nil)
+	assert.Equal(t, http.StatusForbidden, forbidden.Code)
+
+	response := AuthenticatedRequest(server, "GET", "/chat/metrics", token, nil)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	var counters map[string]int64
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &counters))
+	assert.Contains(t, counters, "dropped_frames")
+	assert.Contains(t, counters, "dropped_messages")
+	assert.Contains(t, counters, "slow_consumer_disconnects")
+
+	unauthenticated := AuthenticatedRequest(server, "GET", "/chat/metrics", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, unauthenticated.Code)
+}
+
+func TestTypingFramesAreAcked(t *testing.T) {
+	db.DB = SetupTestDB()
+	server := gin.Default()
+	RegisterRoutes(server)
+	testServer := httptest.NewServer(server)
+	defer testServer.Close()
+
+	aliceID, aliceToken := SignUpAndSignInAs(server, "alice@circle.app")
+	bobID, bobToken := SignUpAndSignInAs(server, "bob@circle.app")
+
+	aliceConn := DialChat(t, testServer, aliceToken)
+	defer aliceConn.Close()
+	bobConn := DialChat(t, testServer, bobToken)
+	defer bobConn.Close()
+
+	assert.Eventually(t, func() bool {
+		return websockets.WSManager.IsOnline(bobID)
+	}, time.Second, 10*time.Millisecond)
+
+	// the repeated start is throttled rather than relayed, but still acked
+	for _, frame := range []struct{ id, state string }{
+		{"t1", websockets.TypingStarted},
+		{"t2", websockets.TypingStarted},
+		{"t3", websockets.TypingStopped},
+	} {
+		aliceConn.WriteJSON(gin.H{"v": websockets.ProtocolVersion, "type": "typing", "id": frame.id, "data": gin.H{"to": "bob@circle.app", "state": frame.state}})
+		ack := ReadFrame(t, aliceConn, nil)
+		assert.Equal(t, "ack", ack.Type)
+		assert.Equal(t, frame.id, ack.ID)
+	}
+
+	var typing websockets.TypingPayload
+	typingFrame := ReadFrame(t, bobConn, &typing)
+	assert.Equal(t, "typing", typingFrame.Type)
+	assert.Equal(t, aliceID, typing.From)
+	assert.Equal(t, websockets.TypingStarted, typing.State)
+	ReadFrame(t, bobConn, &typing)
+	assert.Equal(t, websockets.TypingStopped, typing.State)
+}
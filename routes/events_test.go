@@ -0,0 +1,123 @@
+package routes
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/tenkorangjr/circle-app/db"
+	"github.com/tenkorangjr/circle-app/routes/websockets"
+)
+
+type streamEvent struct {
+	ID    string
+	Event string
+	Data  websockets.Envelope
+}
+
+func OpenEvents(t *testing.T, testServer *httptest.Server, token, lastEventID string) (*http.Response, *bufio.Reader) {
+	req, _ := http.NewRequest("GET", testServer.URL+"/events", nil)
+	req.Header.Set("Authorization", token)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to open event stream: %v", err)
+	}
+	return response, bufio.NewReader(response.Body)
+}
+
+// ReadEvent reads the next event off the stream, skipping keepalive comments.
+func ReadEvent(t *testing.T, reader *bufio.Reader) streamEvent {
+	result := make(chan streamEvent, 1)
+	go func() {
+		var event streamEvent
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\n")
+			switch {
+			case line == "" && event.Event != "":
+				result <- event
+				return
+			case strings.HasPrefix(line, "id:"):
+				event.ID = strings.TrimPrefix(line, "id:")
+			case strings.HasPrefix(line, "event:"):
+				event.Event = strings.TrimPrefix(line, "event:")
+			case strings.HasPrefix(line, "data:"):
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "data:")), &event.Data)
+			}
+		}
+	}()
+
+	select {
+	case event := <-result:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for an event")
+		return streamEvent{}
+	}
+}
+
+func TestEventStreamDeliversAndResumes(t *testing.T) {
+	db.DB = SetupTestDB()
+	server := gin.Default()
+	RegisterRoutes(server)
+	testServer := httptest.NewServer(server)
+	defer testServer.Close()
+
+	settings := websockets.DefaultConfig()
+	settings.StreamRetention = 200 * time.Millisecond
+	websockets.Configure(settings)
+	defer websockets.Configure(websockets.DefaultConfig())
+
+	aliceID, aliceToken := SignUpAndSignInAs(server, "alice@circle.app")
+	bobID, bobToken := SignUpAndSignInAs(server, "bob@circle.app")
+
+	response, reader := OpenEvents(t, testServer, bobToken, "")
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+	assert.Eventually(t, func() bool {
+		return websockets.WSManager.IsOnline(bobID)
+	}, time.Second, 10*time.Millisecond)
+
+	sendResponse := AuthenticatedRequest(server, "POST", "/chat/messages", aliceToken, gin.H{"to": "bob@circle.app", "body": "over sse"})
+	assert.Equal(t, http.StatusCreated, sendResponse.Code)
+
+	first := ReadEvent(t, reader)
+	assert.Equal(t, "message", first.Event)
+	var received websockets.MessagePayload
+	json.Unmarshal(first.Data.Data, &received)
+	assert.Equal(t, aliceID, received.From)
+	assert.Equal(t, "over sse", received.Body)
+
+	// messages sent while the stream is down are picked up on resume
+	response.Body.Close()
+	AuthenticatedRequest(server, "POST", "/chat/messages", aliceToken, gin.H{"to": "bob@circle.app", "body": "missed this"})
+
+	response, reader = OpenEvents(t, testServer, bobToken, first.ID)
+
+	second := ReadEvent(t, reader)
+	json.Unmarshal(second.Data.Data, &received)
+	assert.Equal(t, "missed this", received.Body)
+	assert.NotEqual(t, first.ID, second.ID)
+
+	badResponse := AuthenticatedRequest(server, "POST", "/chat/messages", aliceToken, gin.H{"to": "bob@circle.app", "room": 1, "body": "both"})
+	assert.Equal(t, http.StatusBadRequest, badResponse.Code)
+
+	// a stream that isn't resumed in time is closed
+	response.Body.Close()
+	assert.Eventually(t, func() bool {
+		return !websockets.WSManager.IsOnline(bobID)
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,54 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tenkorangjr/circle-app/db"
+	"github.com/tenkorangjr/circle-app/models"
+	"github.com/tenkorangjr/circle-app/storage"
+	"go.uber.org/zap"
+)
+
+func getFeed(gc *gin.Context) {
+	userId := gc.GetUint("userId")
+	_, limit := parsePagination(gc)
+
+	var cursor *models.FeedCursor
+	if raw := gc.Query("cursor"); raw != "" {
+		var err error
+		cursor, err = models.DecodeFeedCursor(raw)
+		if err != nil {
+			gc.JSON(http.StatusBadRequest, gin.H{"message": "invalid cursor"})
+			return
+		}
+	}
+
+	posts, next, err := models.Feed(db.DB, userId, cursor, limit)
+	if err != nil {
+		zap.S().Error("Failed to load feed", zap.Error(err))
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "failed to load feed"})
+		return
+	}
+
+	for i := range posts {
+		if posts[i].ImageURL == "" {
+			continue
+		}
+
+		// A missing image shouldn't take the rest of the feed down with it
+		url, err := storage.Bucket.SignedURL(gc.Request.Context(), posts[i].ImageURL, signedURLExpiry)
+		if err != nil {
+			zap.S().Error("Failed to generate signed URL", zap.Uint("postID", posts[i].ID), zap.Error(err))
+			continue
+		}
+		posts[i].SignedURL = url
+	}
+
+	nextCursor := ""
+	if next != nil {
+		nextCursor = next.Encode()
+	}
+
+	gc.JSON(http.StatusOK, gin.H{"posts": posts, "next_cursor": nextCursor})
+}
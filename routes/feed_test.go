@@ -0,0 +1,60 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/tenkorangjr/circle-app/db"
+	"github.com/tenkorangjr/circle-app/models"
+)
+
+type feedResponse struct {
+	Posts      []models.FeedPost `json:"posts"`
+	NextCursor string            `json:"next_cursor"`
+}
+
+func TestFeedPaginatesFriendsPosts(t *testing.T) {
+	db.DB = SetupTestDB()
+	server := gin.Default()
+	RegisterRoutes(server)
+
+	aliceID, aliceToken := SignUpAndSignInAs(server, "alice@circle.app")
+	bobID, _ := SignUpAndSignInAs(server, "bob@circle.app")
+	carolID, _ := SignUpAndSignInAs(server, "carol@circle.app")
+
+	request, _ := models.SendFriendRequest(db.DB, aliceID, bobID)
+	request.Accept(db.DB)
+
+	start := time.Now().Add(-time.Hour)
+	for i, authorID := range []uint{aliceID, bobID, carolID, bobID} {
+		post := models.NewPost("", "post", authorID, models.User{})
+		post.CreatedAt = start.Add(time.Duration(i) * time.Minute)
+		db.DB.Omit("User").Create(post)
+	}
+	db.DB.Create(&models.PostLike{PostID: 2, LikerID: aliceID})
+
+	var firstPage feedResponse
+	firstResponse := AuthenticatedRequest(server, "GET", "/feed?limit=2", aliceToken, nil)
+	assert.Equal(t, http.StatusOK, firstResponse.Code)
+	json.Unmarshal(firstResponse.Body.Bytes(), &firstPage)
+
+	// carol is not a friend, so her post is left out
+	assert.Len(t, firstPage.Posts, 2)
+	assert.Equal(t, uint(4), firstPage.Posts[0].ID)
+	assert.Equal(t, uint(2), firstPage.Posts[1].ID)
+	assert.Equal(t, int64(1), firstPage.Posts[1].LikeCount)
+	assert.Equal(t, "bob@circle.app", firstPage.Posts[1].AuthorEmail)
+	assert.NotEmpty(t, firstPage.NextCursor)
+
+	var secondPage feedResponse
+	secondResponse := AuthenticatedRequest(server, "GET", "/feed?limit=2&cursor="+firstPage.NextCursor, aliceToken, nil)
+	json.Unmarshal(secondResponse.Body.Bytes(), &secondPage)
+
+	assert.Len(t, secondPage.Posts, 1)
+	assert.Equal(t, uint(1), secondPage.Posts[0].ID)
+	assert.Empty(t, secondPage.NextCursor)
+}
@@ -0,0 +1,189 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tenkorangjr/circle-app/db"
+	"github.com/tenkorangjr/circle-app/models"
+	requestmodel "github.com/tenkorangjr/circle-app/models/requests"
+	"github.com/tenkorangjr/circle-app/notifications"
+	"go.uber.org/zap"
+)
+
+func sendFriendRequest(gc *gin.Context) {
+	userId := gc.GetUint("userId")
+
+	var request requestmodel.FriendRequestRequest
+	if err := gc.ShouldBindJSON(&request); err != nil || validate.Struct(request) != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": "user_id is required"})
+		return
+	}
+
+	friendRequest, err := models.SendFriendRequest(db.DB, userId, request.UserID)
+	switch {
+	case errors.Is(err, models.ErrUserNotFound):
+		gc.JSON(http.StatusNotFound, gin.H{"message": err.Error()})
+		return
+	case errors.Is(err, models.ErrSelfFriendRequest):
+		gc.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	case errors.Is(err, models.ErrAlreadyFriends), errors.Is(err, models.ErrDuplicateFriendRequest):
+		gc.JSON(http.StatusConflict, gin.H{"message": err.Error()})
+		return
+	case err != nil:
+		zap.S().Error("Failed to send friend request", zap.Error(err))
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "failed to send friend request"})
+		return
+	}
+
+	zap.S().Info("Friend request sent", zap.Uint("requestID", friendRequest.ID))
+	notifications.Notify(models.NotificationFriendRequest, friendRequest.ReceiverID, userId, friendRequest.ID)
+	gc.JSON(http.StatusCreated, gin.H{"message": "friend request sent", "request": friendRequest})
+}
+
+func getFriendRequests(gc *gin.Context) {
+	userId := gc.GetUint("userId")
+
+	column := "receiver_id"
+	switch gc.DefaultQuery("direction", "incoming") {
+	case "incoming":
+	case "outgoing":
+		column = "sender_id"
+	default:
+		gc.JSON(http.StatusBadRequest, gin.H{"message": "direction must be incoming or outgoing"})
+		return
+	}
+
+	var requests []models.FriendRequest
+	if err := db.DB.Where(column+" = ? AND status = ?", userId, models.FriendRequestPending).
+		Order("created_at DESC").
+		Find(&requests).Error; err != nil {
+		zap.S().Error("Failed to list friend requests", zap.Error(err))
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "failed to list friend requests"})
+		return
+	}
+
+	gc.JSON(http.StatusOK, gin.H{"requests": requests})
+}
+
+func acceptFriendRequest(gc *gin.Context) {
+	friendRequest, ok := loadFriendRequest(gc, true)
+	if !ok {
+		return
+	}
+
+	err := friendRequest.Accept(db.DB)
+	if err == nil {
+		notifications.Notify(models.NotificationFriendAccepted, friendRequest.SenderID, friendRequest.ReceiverID, friendRequest.ID)
+	}
+	respondFriendRequestUpdate(gc, friendRequest, err)
+}
+
+func declineFriendRequest(gc *gin.Context) {
+	friendRequest, ok := loadFriendRequest(gc, true)
+	if !ok {
+		return
+	}
+
+	respondFriendRequestUpdate(gc, friendRequest, friendRequest.Decline(db.DB))
+}
+
+func cancelFriendRequest(gc *gin.Context) {
+	friendRequest, ok := loadFriendRequest(gc, false)
+	if !ok {
+		return
+	}
+
+	respondFriendRequestUpdate(gc, friendRequest, friendRequest.Cancel(db.DB))
+}
+
+// loadFriendRequest fetches the request named in the path, making sure the
+// current user is its receiver (or its sender when asReceiver is false). It
+// writes the error response itself and reports whether the caller should
+// carry on.
+func loadFriendRequest(gc *gin.Context, asReceiver bool) (*models.FriendRequest, bool) {
+	userId := gc.GetUint("userId")
+
+	requestId, err := strconv.Atoi(gc.Param("requestid"))
+	if err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": "invalid request id format"})
+		return nil, false
+	}
+
+	var friendRequest models.FriendRequest
+	if err := db.DB.First(&friendRequest, requestId).Error; err != nil ||
+		(friendRequest.SenderID != userId && friendRequest.ReceiverID != userId) {
+		gc.JSON(http.StatusNotFound, gin.H{"message": "friend request not found"})
+		return nil, false
+	}
+
+	if asReceiver && friendRequest.ReceiverID != userId {
+		gc.JSON(http.StatusForbidden, gin.H{"message": "only the receiver can respond to a friend request"})
+		return nil, false
+	}
+	if !asReceiver && friendRequest.SenderID != userId {
+		gc.JSON(http.StatusForbidden, gin.H{"message": "only the sender can cancel a friend request"})
+		return nil, false
+	}
+
+	return &friendRequest, true
+}
+
+func respondFriendRequestUpdate(gc *gin.Context, friendRequest *models.FriendRequest, err error) {
+	if errors.Is(err, models.ErrFriendRequestNotOpen) {
+		gc.JSON(http.StatusConflict, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		zap.S().Error("Failed to update friend request", zap.Error(err))
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "failed to update friend request"})
+		return
+	}
+
+	zap.S().Info("Friend request updated", zap.Uint("requestID", friendRequest.ID), zap.String("status", friendRequest.Status))
+	gc.JSON(http.StatusOK, gin.H{"message": "friend request " + friendRequest.Status, "request": friendRequest})
+}
+
+func getFriends(gc *gin.Context) {
+	userId, err := strconv.Atoi(gc.Param("id"))
+	if err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": "invalid user id format"})
+		return
+	}
+
+	page, limit := parsePagination(gc)
+	friends, total, err := models.ListFriends(db.DB, uint(userId), (page-1)*limit, limit)
+	if err != nil {
+		zap.S().Error("Failed to list friends", zap.Error(err))
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "failed to list friends"})
+		return
+	}
+
+	gc.JSON(http.StatusOK, gin.H{"friends": friends, "page": page, "limit": limit, "total": total})
+}
+
+func unfriend(gc *gin.Context) {
+	userId := gc.GetUint("userId")
+
+	friendId, err := strconv.Atoi(gc.Param("userid"))
+	if err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": "invalid user id format"})
+		return
+	}
+
+	if !models.AreFriends(db.DB, userId, uint(friendId)) {
+		gc.JSON(http.StatusNotFound, gin.H{"message": "users are not friends"})
+		return
+	}
+
+	if err := models.Unfriend(db.DB, userId, uint(friendId)); err != nil {
+		zap.S().Error("Failed to unfriend user", zap.Error(err))
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "failed to unfriend user"})
+		return
+	}
+
+	gc.JSON(http.StatusOK, gin.H{"message": "friend removed"})
+}
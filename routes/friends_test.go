@@ -0,0 +1,110 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/tenkorangjr/circle-app/db"
+	"github.com/tenkorangjr/circle-app/models"
+)
+
+// SignUpAndSignInAs creates a user with the given email and returns their ID
+// and an access token for them.
+func SignUpAndSignInAs(server *gin.Engine, email string) (uint, string) {
+	userBytes, _ := json.Marshal(models.NewUser(email, "admin"))
+	req, _ := http.NewRequest("POST", "/signup", bytes.NewBuffer(userBytes))
+	req.Header.Set("Content-Type", "application/json")
+	responseWriter := httptest.NewRecorder()
+	server.ServeHTTP(responseWriter, req)
+
+	var createdUser models.User
+	json.Unmarshal(responseWriter.Body.Bytes(), &createdUser)
+	// most tests aren't about verification, so skip the emailed link
+	db.DB.Model(&models.User{}).Where("id = ?", createdUser.ID).Update("email_verified_at", time.Now())
+
+	signInBytes, _ := json.Marshal(map[string]string{"email": email, "password": "admin"})
+	req, _ = http.NewRequest("POST", "/signin", bytes.NewBuffer(signInBytes))
+	req.Header.Set("Content-Type", "application/json")
+	responseWriter = httptest.NewRecorder()
+	server.ServeHTTP(responseWriter, req)
+
+	var signInResponse map[string]interface{}
+	json.Unmarshal(responseWriter.Body.Bytes(), &signInResponse)
+	token, _ := signInResponse["token"].(string)
+
+	return createdUser.ID, token
+}
+
+func AuthenticatedRequest(server *gin.Engine, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	var payload []byte
+	if body != nil {
+		payload, _ = json.Marshal(body)
+	}
+
+	req, _ := http.NewRequest(method, path, bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", token)
+	responseWriter := httptest.NewRecorder()
+	server.ServeHTTP(responseWriter, req)
+
+	return responseWriter
+}
+
+func TestFriendRequestWorkflow(t *testing.T) {
+	db.DB = SetupTestDB()
+	server := gin.Default()
+	RegisterRoutes(server)
+
+	aliceID, aliceToken := SignUpAndSignInAs(server, "alice@circle.app")
+	bobID, bobToken := SignUpAndSignInAs(server, "bob@circle.app")
+
+	selfResponse := AuthenticatedRequest(server, "POST", "/friends/requests", aliceToken, gin.H{"user_id": aliceID})
+	assert.Equal(t, http.StatusBadRequest, selfResponse.Code)
+
+	sendResponse := AuthenticatedRequest(server, "POST", "/friends/requests", aliceToken, gin.H{"user_id": bobID})
+	assert.Equal(t, http.StatusCreated, sendResponse.Code)
+
+	// a request in the other direction is a duplicate while this one is pending
+	duplicateResponse := AuthenticatedRequest(server, "POST", "/friends/requests", bobToken, gin.H{"user_id": aliceID})
+	assert.Equal(t, http.StatusConflict, duplicateResponse.Code)
+
+	var incoming struct {
+		Requests []models.FriendRequest `json:"requests"`
+	}
+	incomingResponse := AuthenticatedRequest(server, "GET", "/friends/requests?direction=incoming", bobToken, nil)
+	json.Unmarshal(incomingResponse.Body.Bytes(), &incoming)
+	assert.Len(t, incoming.Requests, 1)
+	requestID := incoming.Requests[0].ID
+
+	// only the receiver may accept
+	forbiddenResponse := AuthenticatedRequest(server, "POST", fmt.Sprintf("/friends/requests/%d/accept", requestID), aliceToken, nil)
+	assert.Equal(t, http.StatusForbidden, forbiddenResponse.Code)
+
+	acceptResponse := AuthenticatedRequest(server, "POST", fmt.Sprintf("/friends/requests/%d/accept", requestID), bobToken, nil)
+	assert.Equal(t, http.StatusOK, acceptResponse.Code)
+
+	assert.True(t, models.AreFriends(db.DB, aliceID, bobID))
+	assert.True(t, models.AreFriends(db.DB, bobID, aliceID))
+
+	var friends struct {
+		Friends []models.UserProfile `json:"friends"`
+		Total   int64                `json:"total"`
+	}
+	friendsResponse := AuthenticatedRequest(server, "GET", fmt.Sprintf("/users/%d/friends", aliceID), aliceToken, nil)
+	json.Unmarshal(friendsResponse.Body.Bytes(), &friends)
+	assert.Equal(t, int64(1), friends.Total)
+	assert.Equal(t, "bob@circle.app", friends.Friends[0].Email)
+
+	unfriendResponse := AuthenticatedRequest(server, "DELETE", fmt.Sprintf("/friends/%d", aliceID), bobToken, nil)
+	assert.Equal(t, http.StatusOK, unfriendResponse.Code)
+
+	assert.False(t, models.AreFriends(db.DB, aliceID, bobID))
+	assert.False(t, models.AreFriends(db.DB, bobID, aliceID))
+}
@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tenkorangjr/circle-app/utils"
+)
+
+// getJWKS publishes the public keys access tokens are signed with, so that
+// other services can verify them without sharing a secret.
+func getJWKS(gc *gin.Context) {
+	manager := utils.SigningKeys()
+	if manager == nil {
+		gc.JSON(http.StatusServiceUnavailable, gin.H{"message": "no signing keys configured"})
+		return
+	}
+
+	gc.Header("Cache-Control", "public, max-age=300")
+	gc.JSON(http.StatusOK, gin.H{"keys": manager.JWKS()})
+}
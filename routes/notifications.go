@@ -0,0 +1,68 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tenkorangjr/circle-app/db"
+	"github.com/tenkorangjr/circle-app/models"
+	"go.uber.org/zap"
+)
+
+// getNotifications lists the caller's notifications, newest activity first.
+// ?unread=true leaves out the ones already read.
+func getNotifications(gc *gin.Context) {
+	userId := gc.GetUint("userId")
+	unreadOnly := gc.Query("unread") == "true"
+
+	page, limit := parsePagination(gc)
+	notifications, total, err := models.ListNotifications(db.DB, userId, unreadOnly, (page-1)*limit, limit)
+	if err != nil {
+		zap.S().Error("Failed to list notifications", zap.Error(err))
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "failed to list notifications"})
+		return
+	}
+
+	unread, err := models.UnreadNotificationCount(db.DB, userId)
+	if err != nil {
+		zap.S().Error("Failed to count notifications", zap.Error(err))
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "failed to list notifications"})
+		return
+	}
+
+	gc.JSON(http.StatusOK, gin.H{"notifications": notifications, "unread": unread, "page": page, "limit": limit, "total": total})
+}
+
+func readNotification(gc *gin.Context) {
+	notificationId, err := strconv.Atoi(gc.Param("notificationid"))
+	if err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": "invalid notification id format"})
+		return
+	}
+
+	err = models.MarkNotificationRead(db.DB, gc.GetUint("userId"), uint(notificationId))
+	if errors.Is(err, models.ErrNotificationNotFound) {
+		gc.JSON(http.StatusNotFound, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		zap.S().Error("Failed to mark notification read", zap.Error(err))
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "failed to mark notification read"})
+		return
+	}
+
+	gc.JSON(http.StatusOK, gin.H{"message": "notification marked read"})
+}
+
+func readAllNotifications(gc *gin.Context) {
+	updated, err := models.MarkAllNotificationsRead(db.DB, gc.GetUint("userId"))
+	if err != nil {
+		zap.S().Error("Failed to mark notifications read", zap.Error(err))
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "failed to mark notifications read"})
+		return
+	}
+
+	gc.JSON(http.StatusOK, gin.H{"message": "notifications marked read", "updated": updated})
+}
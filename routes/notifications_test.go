@@ -0,0 +1,110 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/tenkorangjr/circle-app/db"
+	"github.com/tenkorangjr/circle-app/models"
+	"github.com/tenkorangjr/circle-app/routes/websockets"
+)
+
+type notificationsResponse struct {
+	Notifications []models.Notification `json:"notifications"`
+	Unread        int64                 `json:"unread"`
+	Total         int64                 `json:"total"`
+}
+
+func TestNotificationsCoalesceAndMarkRead(t *testing.T) {
+	db.DB = SetupTestDB()
+	server := gin.Default()
+	RegisterRoutes(server)
+
+	aliceID, aliceToken := SignUpAndSignInAs(server, "alice@circle.app")
+	_, bobToken := SignUpAndSignInAs(server, "bob@circle.app")
+	_, carolToken := SignUpAndSignInAs(server, "carol@circle.app")
+
+	post := models.NewPost("", "hello", aliceID, models.User{})
+	db.DB.Omit("User").Create(post)
+	likePath := fmt.Sprintf("/%d/like", post.ID)
+
+	AuthenticatedRequest(server, "POST", likePath, bobToken, nil)
+	AuthenticatedRequest(server, "POST", likePath, bobToken, nil)
+	AuthenticatedRequest(server, "POST", likePath, carolToken, nil)
+	// liking your own post is not news
+	AuthenticatedRequest(server, "POST", likePath, aliceToken, nil)
+
+	commentPath := fmt.Sprintf("/%d/comment", post.ID)
+	AuthenticatedRequest(server, "POST", commentPath, carolToken, gin.H{"content": "nice one @bob@circle.app"})
+
+	var notifications notificationsResponse
+	listResponse := AuthenticatedRequest(server, "GET", "/notifications", aliceToken, nil)
+	assert.Equal(t, http.StatusOK, listResponse.Code)
+	json.Unmarshal(listResponse.Body.Bytes(), &notifications)
+
+	assert.Equal(t, int64(2), notifications.Unread)
+	assert.Len(t, notifications.Notifications, 2)
+	assert.Equal(t, models.NotificationComment, notifications.Notifications[0].Kind)
+	like := notifications.Notifications[1]
+	assert.Equal(t, models.NotificationLike, like.Kind)
+	assert.Equal(t, 2, like.ActorCount)
+	assert.Equal(t, "carol@circle.app and 1 other liked your post", like.Summary)
+
+	var bobNotifications notificationsResponse
+	bobResponse := AuthenticatedRequest(server, "GET", "/notifications", bobToken, nil)
+	json.Unmarshal(bobResponse.Body.Bytes(), &bobNotifications)
+	assert.Len(t, bobNotifications.Notifications, 1)
+	assert.Equal(t, models.NotificationMention, bobNotifications.Notifications[0].Kind)
+
+	readResponse := AuthenticatedRequest(server, "POST", fmt.Sprintf("/notifications/%d/read", like.ID), aliceToken, nil)
+	assert.Equal(t, http.StatusOK, readResponse.Code)
+	notFoundResponse := AuthenticatedRequest(server, "POST", fmt.Sprintf("/notifications/%d/read", like.ID), bobToken, nil)
+	assert.Equal(t, http.StatusNotFound, notFoundResponse.Code)
+
+	// a like after reading starts a fresh notification
+	AuthenticatedRequest(server, "POST", likePath, bobToken, nil)
+	listResponse = AuthenticatedRequest(server, "GET", "/notifications?unread=true", aliceToken, nil)
+	json.Unmarshal(listResponse.Body.Bytes(), &notifications)
+	assert.Equal(t, int64(2), notifications.Unread)
+	assert.Equal(t, 1, notifications.Notifications[0].ActorCount)
+
+	readAllResponse := AuthenticatedRequest(server, "POST", "/notifications/read", aliceToken, nil)
+	assert.Equal(t, http.StatusOK, readAllResponse.Code)
+	listResponse = AuthenticatedRequest(server, "GET", "/notifications", aliceToken, nil)
+	json.Unmarshal(listResponse.Body.Bytes(), &notifications)
+	assert.Equal(t, int64(0), notifications.Unread)
+	assert.Equal(t, int64(3), notifications.Total)
+}
+
+func TestNotificationsArriveLive(t *testing.T) {
+	db.DB = SetupTestDB()
+	server := gin.Default()
+	RegisterRoutes(server)
+	testServer := httptest.NewServer(server)
+	defer testServer.Close()
+
+	aliceID, aliceToken := SignUpAndSignInAs(server, "alice@circle.app")
+	bobID, bobToken := SignUpAndSignInAs(server, "bob@circle.app")
+
+	aliceConn := DialChat(t, testServer, aliceToken)
+	defer aliceConn.Close()
+	assert.Eventually(t, func() bool {
+		return websockets.WSManager.IsOnline(aliceID)
+	}, time.Second, 10*time.Millisecond)
+
+	AuthenticatedRequest(server, "POST", "/friends/requests", bobToken, gin.H{"user_id": aliceID})
+
+	var payload websockets.NotificationPayload
+	frame := ReadFrame(t, aliceConn, &payload)
+	assert.Equal(t, websockets.FrameNotification, frame.Type)
+	assert.Equal(t, models.NotificationFriendRequest, payload.Notification.Kind)
+	assert.Equal(t, bobID, payload.Notification.ActorID)
+	assert.Equal(t, "bob@circle.app sent you a friend request", payload.Notification.Summary)
+	assert.Equal(t, int64(1), payload.Unread)
+}
@@ -0,0 +1,31 @@
+package routes
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// parsePagination reads ?page= (1-based) and ?limit= from the query string,
+// falling back to sensible defaults for missing or out-of-range values.
+func parsePagination(gc *gin.Context) (page, limit int) {
+	page, err := strconv.Atoi(gc.Query("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	limit, err = strconv.Atoi(gc.Query("limit"))
+	if err != nil || limit < 1 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	return page, limit
+}
@@ -0,0 +1,91 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tenkorangjr/circle-app/db"
+	"github.com/tenkorangjr/circle-app/mail"
+	"github.com/tenkorangjr/circle-app/models"
+	requestmodel "github.com/tenkorangjr/circle-app/models/requests"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const passwordResetTTL = 30 * time.Minute
+
+// forgotPassword emails a reset link to the account with the given email, if
+// there is one. The response is the same either way so that it can't be used
+// to find out who has an account.
+func forgotPassword(gc *gin.Context) {
+	var request requestmodel.ForgotPasswordRequest
+	if err := gc.ShouldBindJSON(&request); err != nil || validate.Struct(request) != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": "a valid email is required"})
+		return
+	}
+
+	var user models.User
+	err := db.DB.Where("email = ?", request.Email).First(&user).Error
+	if err == nil {
+		if err := sendPasswordResetEmail(db.DB, &user); err != nil {
+			zap.S().Error("Failed to send password reset email", zap.Error(err))
+		} else {
+			recordAuditEvent(gc, user.ID, models.AuditPasswordResetRequested)
+		}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		zap.S().Error("Failed to look up user for password reset", zap.Error(err))
+	}
+
+	gc.JSON(http.StatusAccepted, gin.H{"message": "if an account exists for that email, a reset link has been sent to it"})
+}
+
+func sendPasswordResetEmail(db *gorm.DB, user *models.User) error {
+	token, err := models.IssuePasswordReset(db, user.ID, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	return mail.Queue(db, user.Email, "password_reset", gin.H{
+		"Email":     user.Email,
+		"Link":      tokenLink("/password/reset", token),
+		"ExpiresIn": "30 minutes",
+	})
+}
+
+func resetPassword(gc *gin.Context) {
+	var request requestmodel.ResetPasswordRequest
+	if err := gc.ShouldBindJSON(&request); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": "token and password are required"})
+		return
+	}
+	if err := validate.Struct(request); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": "password must be between 8 and 72 characters"})
+		return
+	}
+
+	user, err := models.ResetPassword(db.DB, request.Token, request.Password)
+	if errors.Is(err, models.ErrInvalidResetToken) {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		zap.S().Error("Failed to reset password", zap.Error(err))
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "could not reset password"})
+		return
+	}
+
+	recordAuditEvent(gc, user.ID, models.AuditPasswordReset)
+
+	gc.JSON(http.StatusOK, gin.H{"message": "password has been reset, sign in again with the new one"})
+}
+
+// recordAuditEvent notes that action happened to userId's account during the
+// request. Failing to write it is logged rather than failing the request.
+func recordAuditEvent(gc *gin.Context, userId uint, action string) {
+	event := models.NewAuditEvent(userId, action, gc.ClientIP(), gc.Request.UserAgent())
+	if err := event.Save(db.DB); err != nil {
+		zap.S().Error("Failed to record audit event", zap.String("action", action), zap.Error(err))
+	}
+}
@@ -0,0 +1,73 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/tenkorangjr/circle-app/db"
+	"github.com/tenkorangjr/circle-app/models"
+)
+
+var resetLink = regexp.MustCompile(`/password/reset\?token=(\S+)`)
+
+func PostJSON(server *gin.Engine, path string, body interface{}) *httptest.ResponseRecorder {
+	payload, _ := json.Marshal(body)
+	req, _ := http.NewRequest("POST", path, bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+	responseWriter := httptest.NewRecorder()
+	server.ServeHTTP(responseWriter, req)
+
+	return responseWriter
+}
+
+func TestPasswordReset(t *testing.T) {
+	db.DB = SetupTestDB()
+	server := gin.Default()
+	RegisterRoutes(server)
+
+	userId, accessToken := SignUpAndSignInAs(server, "alice@circle.app")
+
+	known := PostJSON(server, "/password/forgot", gin.H{"email": "alice@circle.app"})
+	unknown := PostJSON(server, "/password/forgot", gin.H{"email": "nobody@circle.app"})
+	assert.Equal(t, http.StatusAccepted, known.Code)
+	assert.Equal(t, known.Code, unknown.Code)
+	assert.Equal(t, known.Body.String(), unknown.Body.String())
+
+	var emails []models.OutboxEmail
+	db.DB.Where("template = ?", "password_reset").Find(&emails)
+	assert.Len(t, emails, 1)
+	assert.Equal(t, "alice@circle.app", emails[0].To)
+	match := resetLink.FindStringSubmatch(emails[0].Body)
+	assert.Len(t, match, 2)
+	token, _ := url.QueryUnescape(match[1])
+
+	shortResponse := PostJSON(server, "/password/reset", gin.H{"token": token, "password": "short"})
+	assert.Equal(t, http.StatusBadRequest, shortResponse.Code)
+
+	resetResponse := PostJSON(server, "/password/reset", gin.H{"token": token, "password": "a much better password"})
+	assert.Equal(t, http.StatusOK, resetResponse.Code)
+
+	// reset tokens work once
+	reuseResponse := PostJSON(server, "/password/reset", gin.H{"token": token, "password": "another password"})
+	assert.Equal(t, http.StatusBadRequest, reuseResponse.Code)
+
+	// every existing session is signed out
+	feedResponse := AuthenticatedRequest(server, "GET", "/feed", accessToken, nil)
+	assert.Equal(t, http.StatusUnauthorized, feedResponse.Code)
+
+	oldSignIn := PostJSON(server, "/signin", gin.H{"email": "alice@circle.app", "password": "admin"})
+	assert.Equal(t, http.StatusUnauthorized, oldSignIn.Code)
+	newSignIn := PostJSON(server, "/signin", gin.H{"email": "alice@circle.app", "password": "a much better password"})
+	assert.Equal(t, http.StatusOK, newSignIn.Code)
+
+	var actions []string
+	db.DB.Model(&models.AuditEvent{}).Where("user_id = ?", userId).Order("id").Pluck("action", &actions)
+	assert.Equal(t, []string{models.AuditPasswordResetRequested, models.AuditPasswordReset}, actions)
+}
@@ -1,6 +1,7 @@
 package routes
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -11,13 +12,17 @@ import (
 	"github.com/tenkorangjr/circle-app/db"
 	"github.com/tenkorangjr/circle-app/models"
 	requestmodel "github.com/tenkorangjr/circle-app/models/requests"
-	"github.com/tenkorangjr/circle-app/utils"
+	"github.com/tenkorangjr/circle-app/notifications"
+	"github.com/tenkorangjr/circle-app/storage"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
-const uploadTimeout = 50 * time.Second
+const (
+	uploadTimeout   = 50 * time.Second
+	signedURLExpiry = 15 * time.Minute
+)
 
 func init() {
 	err := godotenv.Load()
@@ -75,12 +80,14 @@ func createPost(gc *gin.Context) {
 
 		postName := fmt.Sprintf("%d/%d.jpg", userId, post.ID)
 
-		path, err := utils.UploadToBucket(f, postName, gctx, uploadTimeout)
-		if err != nil {
+		uploadCtx, cancel := context.WithTimeout(gctx, uploadTimeout)
+		defer cancel()
+
+		if err := storage.Bucket.Put(uploadCtx, postName, f); err != nil {
 			zap.S().Error("Failed to upload to bucket", zap.Error(err))
 			return err
 		}
-		post.ImageURL = path
+		post.ImageURL = postName
 
 		if err := tx.Save(&post).Error; err != nil {
 			zap.S().Error("Failed to save post", zap.Error(err))
@@ -95,6 +102,7 @@ func createPost(gc *gin.Context) {
 	}
 
 	zap.S().Info("Post created successfully", zap.Uint("postID", post.ID))
+	notifications.NotifyMentions(post.Caption, userId, post.ID)
 	gc.JSON(http.StatusOK, gin.H{"message": "Post created successfully", "post": post})
 }
 
@@ -114,7 +122,7 @@ func getPostbyUserAndPostID(gc *gin.Context) {
 		return
 	}
 
-	url, err := utils.GenerateGetSignedURL(post.ImageURL, gc.Request.Context())
+	url, err := storage.Bucket.SignedURL(gc.Request.Context(), post.ImageURL, signedURLExpiry)
 	if err != nil {
 		zap.S().Error("Failed to generate signed URL", zap.Error(err))
 		gc.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to generate signed URL"})
@@ -160,7 +168,7 @@ func postLike(gc *gin.Context) {
 			return err
 		}
 
-		if err = db.DB.Preload(clause.Associations).
+		if err = tx.Preload(clause.Associations).
 			First(&post, parsedPostID).Error; err != nil {
 			return err
 		}
@@ -183,6 +191,7 @@ func postLike(gc *gin.Context) {
 	}
 
 	zap.S().Info("Like added to post", zap.Uint("postID", post.ID), zap.Int("likesCount", len(post.Likes)))
+	notifications.Notify(models.NotificationLike, post.UserID, userId, post.ID)
 	gc.JSON(http.StatusCreated, gin.H{"message": "like added to post", "likes": len(post.Likes), "post": post})
 }
 
@@ -234,7 +243,7 @@ func postComment(gc *gin.Context) {
 			return err
 		}
 
-		if err = db.DB.Preload(clause.Associations).
+		if err = tx.Preload(clause.Associations).
 			First(&post, parsedPostID).Error; err != nil {
 			return err
 		}
@@ -257,5 +266,7 @@ func postComment(gc *gin.Context) {
 	}
 
 	zap.S().Info("Comment added to post", zap.Uint("postID", post.ID), zap.String("commentContent", comment.Content))
+	notifications.Notify(models.NotificationComment, post.UserID, userId, post.ID)
+	notifications.NotifyMentions(comment.Content, userId, post.ID)
 	gc.JSON(http.StatusCreated, gin.H{"message": "comment added to post", "comment": comment, "post": post})
 }
@@ -0,0 +1,61 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/tenkorangjr/circle-app/db"
+	"github.com/tenkorangjr/circle-app/models"
+	"github.com/tenkorangjr/circle-app/routes/websockets"
+)
+
+func TestPresenceIsSharedWithFriends(t *testing.T) {
+	db.DB = SetupTestDB()
+	server := gin.Default()
+	RegisterRoutes(server)
+	testServer := httptest.NewServer(server)
+	defer testServer.Close()
+
+	aliceID, aliceToken := SignUpAndSignInAs(server, "alice@circle.app")
+	bobID, bobToken := SignUpAndSignInAs(server, "bob@circle.app")
+	carolID, _ := SignUpAndSignInAs(server, "carol@circle.app")
+
+	request, _ := models.SendFriendRequest(db.DB, aliceID, bobID)
+	request.Accept(db.DB)
+
+	aliceConn := DialChat(t, testServer, aliceToken)
+	defer aliceConn.Close()
+	assert.Eventually(t, func() bool {
+		return websockets.WSManager.IsOnline(aliceID)
+	}, time.Second, 10*time.Millisecond)
+
+	bobConn := DialChat(t, testServer, bobToken)
+
+	var change websockets.PresencePayload
+	ReadFrame(t, aliceConn, &change)
+	assert.Equal(t, bobID, change.UserID)
+	assert.Equal(t, websockets.PresenceOnline, change.Status)
+
+	// carol is not a friend, so her status is not disclosed
+	var presence struct {
+		Presence []websockets.PresencePayload `json:"presence"`
+	}
+	presenceResponse := AuthenticatedRequest(server, "GET", fmt.Sprintf("/presence?ids=%d,%d", bobID, carolID), aliceToken, nil)
+	json.Unmarshal(presenceResponse.Body.Bytes(), &presence)
+	assert.Len(t, presence.Presence, 1)
+	assert.Equal(t, websockets.PresenceOnline, presence.Presence[0].Status)
+
+	bobConn.WriteJSON(gin.H{"v": websockets.ProtocolVersion, "type": "presence", "data": gin.H{"status": "away"}})
+	ReadFrame(t, aliceConn, &change)
+	assert.Equal(t, websockets.PresenceAway, change.Status)
+
+	bobConn.Close()
+	ReadFrame(t, aliceConn, &change)
+	assert.Equal(t, websockets.PresenceOffline, change.Status)
+	assert.NotNil(t, change.LastSeen)
+}
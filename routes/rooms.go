@@ -0,0 +1,197 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tenkorangjr/circle-app/db"
+	"github.com/tenkorangjr/circle-app/models"
+	requestmodel "github.com/tenkorangjr/circle-app/models/requests"
+	"go.uber.org/zap"
+)
+
+func createRoom(gc *gin.Context) {
+	userId := gc.GetUint("userId")
+
+	var request requestmodel.CreateRoomRequest
+	if err := gc.ShouldBindJSON(&request); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": "incorrect fields"})
+		return
+	}
+	if err := validate.Struct(request); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": "bad input", "err": err.Error()})
+		return
+	}
+
+	room, err := models.CreateRoom(db.DB, userId, request.Name, request.MemberIDs)
+	if errors.Is(err, models.ErrUserNotFound) {
+		gc.JSON(http.StatusNotFound, gin.H{"message": "one or more members do not exist"})
+		return
+	}
+	if err != nil {
+		zap.S().Error("Failed to create room", zap.Error(err))
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "failed to create room"})
+		return
+	}
+
+	zap.S().Info("Room created", zap.Uint("roomID", room.ID))
+	gc.JSON(http.StatusCreated, gin.H{"message": "room created", "room": room})
+}
+
+func getRooms(gc *gin.Context) {
+	rooms, err := models.RoomsForUser(db.DB, gc.GetUint("userId"))
+	if err != nil {
+		zap.S().Error("Failed to list rooms", zap.Error(err))
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "failed to list rooms"})
+		return
+	}
+
+	gc.JSON(http.StatusOK, gin.H{"rooms": rooms})
+}
+
+func getRoomMembers(gc *gin.Context) {
+	room, ok := loadRoom(gc, false)
+	if !ok {
+		return
+	}
+
+	members, err := models.RoomMembers(db.DB, room.ID)
+	if err != nil {
+		zap.S().Error("Failed to list room members", zap.Error(err))
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "failed to list room members"})
+		return
+	}
+
+	gc.JSON(http.StatusOK, gin.H{"owner_id": room.OwnerID, "members": members})
+}
+
+func addRoomMember(gc *gin.Context) {
+	room, ok := loadRoom(gc, true)
+	if !ok {
+		return
+	}
+
+	var request requestmodel.RoomMemberRequest
+	if err := gc.ShouldBindJSON(&request); err != nil || validate.Struct(request) != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": "user_id is required"})
+		return
+	}
+
+	err := room.AddMember(db.DB, request.UserID)
+	switch {
+	case errors.Is(err, models.ErrUserNotFound):
+		gc.JSON(http.StatusNotFound, gin.H{"message": err.Error()})
+		return
+	case errors.Is(err, models.ErrAlreadyRoomMember):
+		gc.JSON(http.StatusConflict, gin.H{"message": err.Error()})
+		return
+	case err != nil:
+		zap.S().Error("Failed to add room member", zap.Error(err))
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "failed to add member"})
+		return
+	}
+
+	gc.JSON(http.StatusCreated, gin.H{"message": "member added"})
+}
+
+func removeRoomMember(gc *gin.Context) {
+	room, ok := loadRoom(gc, true)
+	if !ok {
+		return
+	}
+
+	memberId, err := strconv.Atoi(gc.Param("userid"))
+	if err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": "invalid user id format"})
+		return
+	}
+	if uint(memberId) == gc.GetUint("userId") {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": "use leave to remove yourself from a room"})
+		return
+	}
+
+	respondRoomMemberRemoval(gc, room.RemoveMember(db.DB, uint(memberId)), "member removed")
+}
+
+func leaveRoom(gc *gin.Context) {
+	room, ok := loadRoom(gc, false)
+	if !ok {
+		return
+	}
+
+	respondRoomMemberRemoval(gc, room.RemoveMember(db.DB, gc.GetUint("userId")), "left room")
+}
+
+func getRoomMessages(gc *gin.Context) {
+	room, ok := loadRoom(gc, false)
+	if !ok {
+		return
+	}
+
+	var before int
+	if raw := gc.Query("before"); raw != "" {
+		var err error
+		before, err = strconv.Atoi(raw)
+		if err != nil || before < 1 {
+			gc.JSON(http.StatusBadRequest, gin.H{"message": "invalid before cursor"})
+			return
+		}
+	}
+
+	_, limit := parsePagination(gc)
+	messages, err := models.RoomMessages(db.DB, room.ID, uint(before), limit)
+	if err != nil {
+		zap.S().Error("Failed to load room messages", zap.Error(err))
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "failed to load room messages"})
+		return
+	}
+
+	var nextBefore uint
+	if len(messages) == limit {
+		nextBefore = messages[len(messages)-1].ID
+	}
+
+	gc.JSON(http.StatusOK, gin.H{"messages": messages, "next_before": nextBefore})
+}
+
+// loadRoom fetches the room named in the path, making sure the current user
+// is a member (and its owner when ownerOnly is set). It writes the error
+// response itself and reports whether the caller should carry on.
+func loadRoom(gc *gin.Context, ownerOnly bool) (*models.ChatRoom, bool) {
+	userId := gc.GetUint("userId")
+
+	roomId, err := strconv.Atoi(gc.Param("roomid"))
+	if err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": "invalid room id format"})
+		return nil, false
+	}
+
+	var room models.ChatRoom
+	if err := db.DB.First(&room, roomId).Error; err != nil || !models.IsRoomMember(db.DB, room.ID, userId) {
+		gc.JSON(http.StatusNotFound, gin.H{"message": "room not found"})
+		return nil, false
+	}
+
+	if ownerOnly && room.OwnerID != userId {
+		gc.JSON(http.StatusForbidden, gin.H{"message": "only the room owner can manage members"})
+		return nil, false
+	}
+
+	return &room, true
+}
+
+func respondRoomMemberRemoval(gc *gin.Context, err error, message string) {
+	if errors.Is(err, models.ErrNotRoomMember) {
+		gc.JSON(http.StatusNotFound, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		zap.S().Error("Failed to remove room member", zap.Error(err))
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "failed to remove member"})
+		return
+	}
+
+	gc.JSON(http.StatusOK, gin.H{"message": message})
+}
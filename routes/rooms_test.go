@@ -0,0 +1,88 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/tenkorangjr/circle-app/db"
+	"github.com/tenkorangjr/circle-app/models"
+	"github.com/tenkorangjr/circle-app/routes/websockets"
+)
+
+func TestRoomMessagesReachOnlyMembers(t *testing.T) {
+	db.DB = SetupTestDB()
+	server := gin.Default()
+	RegisterRoutes(server)
+	testServer := httptest.NewServer(server)
+	defer testServer.Close()
+
+	aliceID, aliceToken := SignUpAndSignInAs(server, "alice@circle.app")
+	bobID, bobToken := SignUpAndSignInAs(server, "bob@circle.app")
+	carolID, carolToken := SignUpAndSignInAs(server, "carol@circle.app")
+
+	var created struct {
+		Room models.ChatRoom `json:"room"`
+	}
+	createResponse := AuthenticatedRequest(server, "POST", "/rooms", aliceToken, gin.H{"name": "circle", "member_ids": []uint{bobID}})
+	assert.Equal(t, http.StatusCreated, createResponse.Code)
+	json.Unmarshal(createResponse.Body.Bytes(), &created)
+	roomID := created.Room.ID
+
+	// only the owner manages members
+	forbiddenResponse := AuthenticatedRequest(server, "POST", fmt.Sprintf("/rooms/%d/members", roomID), bobToken, gin.H{"user_id": carolID})
+	assert.Equal(t, http.StatusForbidden, forbiddenResponse.Code)
+
+	var members struct {
+		OwnerID uint                 `json:"owner_id"`
+		Members []models.UserProfile `json:"members"`
+	}
+	membersResponse := AuthenticatedRequest(server, "GET", fmt.Sprintf("/rooms/%d/members", roomID), bobToken, nil)
+	assert.Equal(t, http.StatusOK, membersResponse.Code)
+	json.Unmarshal(membersResponse.Body.Bytes(), &members)
+	assert.Equal(t, aliceID, members.OwnerID)
+	assert.Equal(t, []models.UserProfile{{ID: aliceID, Email: "alice@circle.app"}, {ID: bobID, Email: "bob@circle.app"}}, members.Members)
+
+	// outsiders can't see who is in a room
+	membersResponse = AuthenticatedRequest(server, "GET", fmt.Sprintf("/rooms/%d/members", roomID), carolToken, nil)
+	assert.Equal(t, http.StatusNotFound, membersResponse.Code)
+
+	bobConn := DialChat(t, testServer, bobToken)
+	defer bobConn.Close()
+	carolConn := DialChat(t, testServer, carolToken)
+	defer carolConn.Close()
+	aliceConn := DialChat(t, testServer, aliceToken)
+	defer aliceConn.Close()
+
+	assert.Eventually(t, func() bool {
+		return websockets.WSManager.IsOnline(bobID)
+	}, time.Second, 10*time.Millisecond)
+
+	aliceConn.WriteJSON(gin.H{"v": websockets.ProtocolVersion, "type": "message", "id": "m1", "data": gin.H{"room": roomID, "body": "hi all"}})
+	ReadFrame(t, aliceConn, nil)
+
+	var received websockets.MessagePayload
+	ReadFrame(t, bobConn, &received)
+	assert.Equal(t, roomID, received.Room)
+	assert.Equal(t, aliceID, received.From)
+	assert.Equal(t, "hi all", received.Body)
+
+	var errorPayload websockets.ErrorPayload
+	carolConn.WriteJSON(gin.H{"v": websockets.ProtocolVersion, "type": "message", "id": "m2", "data": gin.H{"room": roomID, "body": "let me in"}})
+	ReadFrame(t, carolConn, &errorPayload)
+	assert.Equal(t, websockets.ErrCodeNotRoomMember, errorPayload.Code)
+
+	// ownership passes on when the owner leaves
+	leaveResponse := AuthenticatedRequest(server, "POST", fmt.Sprintf("/rooms/%d/leave", roomID), aliceToken, nil)
+	assert.Equal(t, http.StatusOK, leaveResponse.Code)
+
+	var room models.ChatRoom
+	db.DB.First(&room, roomID)
+	assert.Equal(t, bobID, room.OwnerID)
+	assert.False(t, models.IsRoomMember(db.DB, roomID, aliceID))
+}
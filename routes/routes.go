@@ -5,20 +5,76 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/tenkorangjr/circle-app/middleware"
+	"github.com/tenkorangjr/circle-app/models"
 	"github.com/tenkorangjr/circle-app/routes/websockets"
+	"github.com/tenkorangjr/circle-app/storage"
 )
 
 func RegisterRoutes(server *gin.Engine) {
-	server.Use(middleware.RateLimiter(5, time.Second))
+	authLimit := middleware.RateLimiter(5, time.Minute)
+	readLimit := middleware.RateLimiter(20, time.Second)
+	writeLimit := middleware.RateLimiter(5, time.Second)
+	verified := middleware.RequireVerifiedEmail
+
 	// user routes
-	server.POST("/signup", signUp)
-	server.POST("/signin", signIn)
+	server.POST("/signup", authLimit, signUp)
+	server.POST("/signin", authLimit, signIn)
+	server.POST("/signin/2fa", authLimit, completeTwoFactorSignIn)
+	server.POST("/token/refresh", authLimit, refreshToken)
+	server.POST("/signout", authLimit, signOut)
+	server.GET("/verify-email", authLimit, verifyEmail)
+	server.POST("/password/forgot", authLimit, forgotPassword)
+	server.POST("/password/reset", authLimit, resetPassword)
+
+	server.GET("/.well-known/jwks.json", readLimit, getJWKS)
+
+	// signed URLs handed out by the local storage backend
+	server.GET(storage.LocalFilesPath+"/*name", readLimit, storage.ServeLocalFile)
 
 	authenticated := server.Group("/")
 	authenticated.Use(middleware.Authenticate)
-	authenticated.POST("/posts", createPost)
-	authenticated.GET("/:id/:postid", getPostbyUserAndPostID)
-	authenticated.POST("/:postid/comment", postComment)
-	authenticated.POST("/:postid/like", postLike)
-	authenticated.GET("/chat", websockets.HandleWs)
+	authenticated.POST("/verify-email/resend", authLimit, resendVerificationEmail)
+	authenticated.POST("/2fa/enroll", authLimit, middleware.RequireSession, enrollTwoFactor)
+	authenticated.POST("/2fa/confirm", authLimit, middleware.RequireSession, confirmTwoFactor)
+	authenticated.POST("/2fa/disable", authLimit, middleware.RequireSession, disableTwoFactor)
+	authenticated.POST("/posts", writeLimit, verified(middleware.ActionPost), createPost)
+	authenticated.GET("/feed", readLimit, getFeed)
+	authenticated.GET("/:id/:postid", readLimit, getPostbyUserAndPostID)
+	authenticated.POST("/:postid/comment", writeLimit, verified(middleware.ActionPost), postComment)
+	authenticated.POST("/:postid/like", writeLimit, verified(middleware.ActionPost), postLike)
+	// the websocket sends messages as well as receiving them
+	authenticated.GET("/chat", writeLimit, middleware.RequireScope(models.ScopeWrite), verified(middleware.ActionChat), websockets.HandleWs)
+	authenticated.GET("/chat/:userid/messages", readLimit, getConversation)
+	authenticated.POST("/chat/messages", writeLimit, verified(middleware.ActionChat), sendChatMessage)
+	authenticated.GET("/events", readLimit, verified(middleware.ActionChat), websockets.HandleEvents)
+	authenticated.GET("/presence", readLimit, websockets.HandlePresence)
+	authenticated.GET("/chat/metrics", readLimit, middleware.RequireOperator(), websockets.HandleMetrics)
+
+	// chat room routes
+	authenticated.POST("/rooms", writeLimit, verified(middleware.ActionChat), createRoom)
+	authenticated.GET("/rooms", readLimit, getRooms)
+	authenticated.GET("/rooms/:roomid/members", readLimit, getRoomMembers)
+	authenticated.POST("/rooms/:roomid/members", writeLimit, addRoomMember)
+	authenticated.DELETE("/rooms/:roomid/members/:userid", writeLimit, removeRoomMember)
+	authenticated.POST("/rooms/:roomid/leave", writeLimit, leaveRoom)
+	authenticated.GET("/rooms/:roomid/messages", readLimit, getRoomMessages)
+
+	// friend routes
+	authenticated.POST("/friends/requests", writeLimit, verified(middleware.ActionFriends), sendFriendRequest)
+	authenticated.GET("/friends/requests", readLimit, getFriendRequests)
+	authenticated.POST("/friends/requests/:requestid/accept", writeLimit, acceptFriendRequest)
+	authenticated.POST("/friends/requests/:requestid/decline", writeLimit, declineFriendRequest)
+	authenticated.DELETE("/friends/requests/:requestid", writeLimit, cancelFriendRequest)
+	authenticated.DELETE("/friends/:userid", writeLimit, unfriend)
+	authenticated.GET("/users/:id/friends", readLimit, getFriends)
+
+	// personal access token routes
+	authenticated.POST("/tokens", authLimit, middleware.RequireSession, createAccessToken)
+	authenticated.GET("/tokens", readLimit, middleware.RequireSession, getAccessTokens)
+	authenticated.DELETE("/tokens/:tokenid", writeLimit, middleware.RequireSession, revokeAccessToken)
+
+	// notification routes
+	authenticated.GET("/notifications", readLimit, getNotifications)
+	authenticated.POST("/notifications/read", writeLimit, readAllNotifications)
+	authenticated.POST("/notifications/:notificationid/read", writeLimit, readNotification)
 }
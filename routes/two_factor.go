@@ -0,0 +1,140 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tenkorangjr/circle-app/db"
+	"github.com/tenkorangjr/circle-app/models"
+	requestmodel "github.com/tenkorangjr/circle-app/models/requests"
+	"go.uber.org/zap"
+)
+
+const twoFactorChallengeTTL = 5 * time.Minute
+
+func enrollTwoFactor(gc *gin.Context) {
+	var user models.User
+	if err := db.DB.First(&user, gc.GetUint("userId")).Error; err != nil {
+		gc.JSON(http.StatusNotFound, gin.H{"message": "user not found"})
+		return
+	}
+
+	secret, uri, err := models.BeginTwoFactorEnrollment(db.DB, &user)
+	if errors.Is(err, models.ErrTwoFactorAlreadyEnabled) {
+		gc.JSON(http.StatusConflict, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		zap.S().Error("Failed to start two-factor enrollment", zap.Error(err))
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "failed to start two-factor enrollment"})
+		return
+	}
+
+	gc.JSON(http.StatusOK, gin.H{
+		"message":     "add this secret to your authenticator app, then confirm with a code from it",
+		"secret":      secret,
+		"otpauth_uri": uri,
+	})
+}
+
+func confirmTwoFactor(gc *gin.Context) {
+	var request requestmodel.TwoFactorCodeRequest
+	if err := gc.ShouldBindJSON(&request); err != nil || validate.Struct(request) != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": "code is required"})
+		return
+	}
+
+	codes, err := models.ConfirmTwoFactor(db.DB, gc.GetUint("userId"), request.Code)
+	switch {
+	case errors.Is(err, models.ErrInvalidTwoFactorCode):
+		gc.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	case errors.Is(err, models.ErrTwoFactorAlreadyEnabled), errors.Is(err, models.ErrTwoFactorNotPending):
+		gc.JSON(http.StatusConflict, gin.H{"message": err.Error()})
+		return
+	case err != nil:
+		zap.S().Error("Failed to confirm two-factor enrollment", zap.Error(err))
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "failed to confirm two-factor enrollment"})
+		return
+	}
+
+	recordAuditEvent(gc, gc.GetUint("userId"), models.AuditTwoFactorEnabled)
+
+	gc.JSON(http.StatusOK, gin.H{
+		"message":        "two-factor authentication enabled, keep these recovery codes somewhere safe",
+		"recovery_codes": codes,
+	})
+}
+
+func disableTwoFactor(gc *gin.Context) {
+	var request requestmodel.TwoFactorCodeRequest
+	if err := gc.ShouldBindJSON(&request); err != nil || validate.Struct(request) != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": "code is required"})
+		return
+	}
+
+	err := models.DisableTwoFactor(db.DB, gc.GetUint("userId"), request.Code)
+	switch {
+	case errors.Is(err, models.ErrInvalidTwoFactorCode):
+		gc.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	case errors.Is(err, models.ErrTwoFactorNotEnabled):
+		gc.JSON(http.StatusConflict, gin.H{"message": err.Error()})
+		return
+	case err != nil:
+		zap.S().Error("Failed to disable two-factor authentication", zap.Error(err))
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "failed to disable two-factor authentication"})
+		return
+	}
+
+	recordAuditEvent(gc, gc.GetUint("userId"), models.AuditTwoFactorDisabled)
+
+	gc.JSON(http.StatusOK, gin.H{"message": "two-factor authentication disabled"})
+}
+
+// completeTwoFactorSignIn exchanges the challenge from a signin and a code
+// for the tokens the signin would otherwise have returned.
+func completeTwoFactorSignIn(gc *gin.Context) {
+	var request requestmodel.TwoFactorSignInRequest
+	if err := gc.ShouldBindJSON(&request); err != nil || validate.Struct(request) != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": "challenge_token and code are required"})
+		return
+	}
+
+	challenged, err := models.TwoFactorChallengeUser(db.DB, request.ChallengeToken)
+	if errors.Is(err, models.ErrInvalidChallenge) {
+		gc.JSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		zap.S().Error("Failed to look up two-factor challenge", zap.Error(err))
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "Could not start session"})
+		return
+	}
+
+	// wrong codes count as failed signins, just like wrong passwords
+	accountKey := models.AccountSigninKey(challenged.Email)
+	ipKey := models.IPSigninKey(gc.ClientIP())
+	if signinBlocked(gc, accountKey, ipKey) {
+		return
+	}
+
+	user, err := models.CompleteTwoFactorChallenge(db.DB, request.ChallengeToken, request.Code)
+	switch {
+	case errors.Is(err, models.ErrInvalidChallenge), errors.Is(err, models.ErrTwoFactorNotEnabled):
+		gc.JSON(http.StatusUnauthorized, gin.H{"message": models.ErrInvalidChallenge.Error()})
+		return
+	case errors.Is(err, models.ErrInvalidTwoFactorCode):
+		recordSigninFailure(gc, challenged.ID, accountKey, ipKey)
+		gc.JSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
+		return
+	case err != nil:
+		zap.S().Error("Failed to complete two-factor signin", zap.Error(err))
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "Could not start session"})
+		return
+	}
+
+	startSession(gc, user)
+}
@@ -0,0 +1,171 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/tenkorangjr/circle-app/db"
+	"github.com/tenkorangjr/circle-app/models"
+	"github.com/tenkorangjr/circle-app/utils"
+)
+
+func TestTwoFactorSignIn(t *testing.T) {
+	db.DB = SetupTestDB()
+	server := gin.Default()
+	RegisterRoutes(server)
+
+	_, token := SignUpAndSignInAs(server, "alice@circle.app")
+
+	var enrollment map[string]string
+	enrollResponse := AuthenticatedRequest(server, "POST", "/2fa/enroll", token, nil)
+	assert.Equal(t, http.StatusOK, enrollResponse.Code)
+	json.Unmarshal(enrollResponse.Body.Bytes(), &enrollment)
+	secret := enrollment["secret"]
+	assert.Contains(t, enrollment["otpauth_uri"], "secret="+secret)
+
+	wrongResponse := AuthenticatedRequest(server, "POST", "/2fa/confirm", token, gin.H{"code": "000000x"})
+	assert.Equal(t, http.StatusBadRequest, wrongResponse.Code)
+
+	step := utils.TOTPStep(time.Now())
+	code, _ := utils.TOTPCode(secret, step)
+	var confirmation struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	confirmResponse := AuthenticatedRequest(server, "POST", "/2fa/confirm", token, gin.H{"code": code})
+	assert.Equal(t, http.StatusOK, confirmResponse.Code)
+	json.Unmarshal(confirmResponse.Body.Bytes(), &confirmation)
+	assert.Len(t, confirmation.RecoveryCodes, 10)
+
+	enrollResponse = AuthenticatedRequest(server, "POST", "/2fa/enroll", token, nil)
+	assert.Equal(t, http.StatusConflict, enrollResponse.Code)
+
+	// the password alone now only gets a challenge
+	var signIn map[string]interface{}
+	signInResponse := PostJSON(server, "/signin", gin.H{"email": "alice@circle.app", "password": "admin"})
+	json.Unmarshal(signInResponse.Body.Bytes(), &signIn)
+	assert.Equal(t, true, signIn["two_factor_required"])
+	assert.Nil(t, signIn["token"])
+	challenge, _ := signIn["challenge_token"].(string)
+
+	// codes can't be replayed
+	replayResponse := PostJSON(server, "/signin/2fa", gin.H{"challenge_token": challenge, "code": code})
+	assert.Equal(t, http.StatusUnauthorized, replayResponse.Code)
+
+	nextCode, _ := utils.TOTPCode(secret, step+1)
+	completeResponse := PostJSON(server, "/signin/2fa", gin.H{"challenge_token": challenge, "code": nextCode})
+	assert.Equal(t, http.StatusOK, completeResponse.Code)
+	var session map[string]interface{}
+	json.Unmarshal(completeResponse.Body.Bytes(), &session)
+	assert.NotEmpty(t, session["token"])
+	assert.NotEmpty(t, session["refresh_token"])
+
+	reuseResponse := PostJSON(server, "/signin/2fa", gin.H{"challenge_token": challenge, "code": nextCode})
+	assert.Equal(t, http.StatusUnauthorized, reuseResponse.Code)
+
+	// recovery codes stand in for the app, once each
+	signInResponse = PostJSON(server, "/signin", gin.H{"email": "alice@circle.app", "password": "admin"})
+	json.Unmarshal(signInResponse.Body.Bytes(), &signIn)
+	challenge, _ = signIn["challenge_token"].(string)
+	recoveryResponse := PostJSON(server, "/signin/2fa", gin.H{"challenge_token": challenge, "code": confirmation.RecoveryCodes[0]})
+	assert.Equal(t, http.StatusOK, recoveryResponse.Code)
+
+	disableResponse := AuthenticatedRequest(server, "POST", "/2fa/disable", token, gin.H{"code": confirmation.RecoveryCodes[0]})
+	assert.Equal(t, http.StatusBadRequest, disableResponse.Code)
+	disableResponse = AuthenticatedRequest(server, "POST", "/2fa/disable", token, gin.H{"code": confirmation.RecoveryCodes[1]})
+	assert.Equal(t, http.StatusOK, disableResponse.Code)
+
+	signInResponse = PostJSON(server, "/signin", gin.H{"email": "alice@circle.app", "password": "admin"})
+	json.Unmarshal(signInResponse.Body.Bytes(), &signIn)
+	assert.NotEmpty(t, signIn["token"])
+}
+
+func TestTwoFactorChallengeGivesUpAfterWrongCodes(t *testing.T) {
+	db.DB = SetupTestDB()
+
+	user := models.NewUser("bob@circle.app", "admin")
+	user.Save(db.DB)
+	secret, _, err := models.BeginTwoFactorEnrollment(db.DB, user)
+	assert.NoError(t, err)
+	code, _ := utils.TOTPCode(secret, utils.TOTPStep(time.Now()))
+	recoveryCodes, err := models.ConfirmTwoFactor(db.DB, user.ID, code)
+	assert.NoError(t, err)
+
+	challenge, err := models.IssueTwoFactorChallenge(db.DB, user.ID, time.Minute)
+	assert.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		_, err := models.CompleteTwoFactorChallenge(db.DB, challenge, "000000")
+		assert.ErrorIs(t, err, models.ErrInvalidTwoFactorCode)
+	}
+
+	_, err = models.CompleteTwoFactorChallenge(db.DB, challenge, recoveryCodes[0])
+	assert.ErrorIs(t, err, models.ErrInvalidChallenge)
+}
+
+func TestRecoveryCodesAreSalted(t *testing.T) {
+	db.DB = SetupTestDB()
+
+	user := models.NewUser("carol@circle.app", "admin")
+	user.Save(db.DB)
+	secret, _, err := models.BeginTwoFactorEnrollment(db.DB, user)
+	assert.NoError(t, err)
+	code, _ := utils.TOTPCode(secret, utils.TOTPStep(time.Now()))
+	recoveryCodes, err := models.ConfirmTwoFactor(db.DB, user.ID, code)
+	assert.NoError(t, err)
+
+	var stored []models.RecoveryCode
+	db.DB.Where("user_id = ?", user.ID).Find(&stored)
+	assert.Len(t, stored, len(recoveryCodes))
+	for _, recoveryCode := range stored {
+		assert.True(t, strings.HasPrefix(recoveryCode.CodeHash, "$2"))
+	}
+
+	// codes can be typed back without the dash and in any case
+	typed := strings.ToUpper(strings.ReplaceAll(recoveryCodes[3], "-", ""))
+	challenge, err := models.IssueTwoFactorChallenge(db.DB, user.ID, time.Minute)
+	assert.NoError(t, err)
+	_, err = models.CompleteTwoFactorChallenge(db.DB, challenge, typed)
+	assert.NoError(t, err)
+}
+
+func TestWrongTwoFactorCodesCountAsFailedSignins(t *testing.T) {
+	db.DB = SetupTestDB()
+	server := gin.Default()
+	RegisterRoutes(server)
+
+	defaultPolicy := models.AccountSigninPolicy
+	models.AccountSigninPolicy = models.SigninPolicy{FreeFailures: 3, LockAfter: 3, LockFor: time.Minute}
+	defer func() { models.AccountSigninPolicy = defaultPolicy }()
+
+	userId, _ := SignUpAndSignInAs(server, "alice@circle.app")
+	var user models.User
+	db.DB.First(&user, userId)
+	secret, _, _ := models.BeginTwoFactorEnrollment(db.DB, &user)
+	step := utils.TOTPStep(time.Now())
+	code, _ := utils.TOTPCode(secret, step)
+	models.ConfirmTwoFactor(db.DB, userId, code)
+
+	PostJSON(server, "/signin", gin.H{"email": "alice@circle.app", "password": "guess"})
+
+	// the right password alone doesn't wipe the slate clean
+	var signIn map[string]interface{}
+	signInResponse := PostJSON(server, "/signin", gin.H{"email": "alice@circle.app", "password": "admin"})
+	json.Unmarshal(signInResponse.Body.Bytes(), &signIn)
+	challenge, _ := signIn["challenge_token"].(string)
+
+	PostJSON(server, "/signin/2fa", gin.H{"challenge_token": challenge, "code": "000000"})
+	wrongResponse := PostJSON(server, "/signin/2fa", gin.H{"challenge_token": challenge, "code": "000000"})
+	assert.Equal(t, http.StatusUnauthorized, wrongResponse.Code)
+
+	nextCode, _ := utils.TOTPCode(secret, step+1)
+	lockedResponse := PostJSON(server, "/signin/2fa", gin.H{"challenge_token": challenge, "code": nextCode})
+	assert.Equal(t, http.StatusTooManyRequests, lockedResponse.Code)
+
+	var event models.AuditEvent
+	assert.NoError(t, db.DB.Where("action = ?", models.AuditAccountLocked).First(&event).Error)
+	assert.Equal(t, userId, event.UserID)
+}
@@ -1,13 +1,21 @@
 package routes
 
 import (
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
 	"github.com/tenkorangjr/circle-app/db"
 	"github.com/tenkorangjr/circle-app/models"
+	requestmodel "github.com/tenkorangjr/circle-app/models/requests"
 	"github.com/tenkorangjr/circle-app/utils"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
 var validate = validator.New(validator.WithRequiredStructEnabled())
@@ -27,12 +35,19 @@ func signUp(context *gin.Context) {
 		return
 	}
 
+	// only following the emailed link verifies an address
+	user.EmailVerifiedAt = nil
+
 	err = user.Save(db.DB)
 	if err != nil {
 		context.JSON(http.StatusInternalServerError, gin.H{"message": "Unable to save user to db", "error": err})
 		return
 	}
 
+	if err := sendVerificationEmail(db.DB, &user); err != nil {
+		zap.S().Error("Failed to queue verification email", zap.Error(err))
+	}
+
 	context.JSON(http.StatusCreated, user)
 }
 
@@ -46,23 +61,185 @@ func signIn(context *gin.Context) {
 		return
 	}
 
+	accountKey := models.AccountSigninKey(user.Email)
+	ipKey := models.IPSigninKey(context.ClientIP())
+	if signinBlocked(context, accountKey, ipKey) {
+		return
+	}
+
 	var queryUser models.User
-	db.DB.Where("email = ?", user.Email).First(&queryUser)
-	if queryUser.Email == "" {
-		context.JSON(http.StatusNotFound, gin.H{"message": "Email not found"})
+	err = db.DB.Where("email = ?", user.Email).First(&queryUser).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		zap.S().Error("Failed to look up user", zap.Error(err))
+		context.JSON(http.StatusInternalServerError, gin.H{"message": "Could not sign in"})
 		return
 	}
 
-	if !utils.ValidatePassword(queryUser.Password, user.Password) {
+	// an unknown email is checked against a stand-in hash so that it takes as
+	// long, and looks the same, as a wrong password
+	hashedPassword := dummyPasswordHash()
+	if err == nil {
+		hashedPassword = queryUser.Password
+	}
+	if !utils.ValidatePassword(hashedPassword, user.Password) || err != nil {
+		recordSigninFailure(context, queryUser.ID, accountKey, ipKey)
 		context.JSON(http.StatusUnauthorized, gin.H{"message": "Invalid credentials"})
 		return
 	}
 
-	token, err := utils.GenerateJWT(queryUser.ID, queryUser.Email)
+	// failures are only cleared once a session starts, so that guessing two
+	// factor codes stays throttled for someone who knows the password
+	if models.TwoFactorEnabled(db.DB, queryUser.ID) {
+		challengeToken, err := models.IssueTwoFactorChallenge(db.DB, queryUser.ID, twoFactorChallengeTTL)
+		if err != nil {
+			zap.S().Error("Failed to issue two-factor challenge", zap.Error(err))
+			context.JSON(http.StatusInternalServerError, gin.H{"message": "Could not start session"})
+			return
+		}
+
+		context.JSON(http.StatusOK, gin.H{
+			"message":             "Two-factor code required",
+			"two_factor_required": true,
+			"challenge_token":     challengeToken,
+			"expires_in":          int(twoFactorChallengeTTL.Seconds()),
+		})
+		return
+	}
+
+	startSession(context, &queryUser)
+}
+
+// signinBlocked responds for a signin that is being held back because of
+// earlier failures against any of keys, and reports whether it did.
+func signinBlocked(context *gin.Context, keys ...string) bool {
+	wait, err := models.SigninBlockedFor(db.DB, time.Now(), keys...)
+	if err != nil {
+		zap.S().Error("Failed to check signin throttling", zap.Error(err))
+		context.JSON(http.StatusInternalServerError, gin.H{"message": "Could not sign in"})
+		return true
+	}
+	if wait > 0 {
+		context.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		context.JSON(http.StatusTooManyRequests, gin.H{"message": "Too many failed signin attempts, try again later"})
+		return true
+	}
+
+	return false
+}
+
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := utils.HashPassword("not anyone's password")
+	return hash
+})
+
+// recordSigninFailure counts a failed signin against both the account and
+// the IP it came from, noting in the audit log if either gets locked out.
+func recordSigninFailure(context *gin.Context, userId uint, accountKey, ipKey string) {
+	now := time.Now()
+
+	locked, err := models.RecordSigninFailure(db.DB, accountKey, models.AccountSigninPolicy, now)
+	if err != nil {
+		zap.S().Error("Failed to record signin failure", zap.Error(err))
+	} else if locked {
+		recordAuditEvent(context, userId, models.AuditAccountLocked)
+	}
+
+	locked, err = models.RecordSigninFailure(db.DB, ipKey, models.IPSigninPolicy, now)
+	if err != nil {
+		zap.S().Error("Failed to record signin failure", zap.Error(err))
+	} else if locked {
+		recordAuditEvent(context, 0, models.AuditIPLocked)
+	}
+}
+
+// startSession signs user in, responding with their access and refresh
+// tokens, and forgets the failed signins counted against their account.
+func startSession(context *gin.Context, user *models.User) {
+	if err := models.ClearSigninFailures(db.DB, models.AccountSigninKey(user.Email)); err != nil {
+		zap.S().Error("Failed to clear signin failures", zap.Error(err))
+	}
+
+	session := models.NewSession(user.ID)
+	refreshToken, err := session.Start(db.DB)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"message": "Could not start session"})
+		return
+	}
+
+	token, err := utils.GenerateJWT(user.ID, user.Email, session.ID)
 	if err != nil {
 		context.JSON(http.StatusInternalServerError, gin.H{"message": "Could not generate JWT token"})
 		return
 	}
 
-	context.JSON(http.StatusOK, gin.H{"message": "User logged in successfully", "token": token})
+	context.JSON(http.StatusOK, gin.H{
+		"message":        "User logged in successfully",
+		"token":          token,
+		"refresh_token":  refreshToken,
+		"expires_in":     int(utils.AccessTokenTTL.Seconds()),
+		"email_verified": user.EmailVerifiedAt != nil,
+	})
+}
+
+func refreshToken(context *gin.Context) {
+	var request requestmodel.RefreshTokenRequest
+	if err := context.ShouldBindJSON(&request); err != nil || validate.Struct(request) != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"message": "refresh_token is required"})
+		return
+	}
+
+	session, newRefreshToken, err := models.RotateRefreshToken(db.DB, request.RefreshToken)
+	if errors.Is(err, models.ErrRefreshTokenReused) {
+		zap.S().Warn("Refresh token reuse detected, session revoked")
+		context.JSON(http.StatusUnauthorized, gin.H{"message": "refresh token has already been used"})
+		return
+	}
+	if errors.Is(err, models.ErrInvalidRefreshToken) {
+		context.JSON(http.StatusUnauthorized, gin.H{"message": "invalid refresh token"})
+		return
+	}
+	if err != nil {
+		zap.S().Error("Failed to rotate refresh token", zap.Error(err))
+		context.JSON(http.StatusInternalServerError, gin.H{"message": "Could not refresh token"})
+		return
+	}
+
+	var user models.User
+	if err := db.DB.First(&user, session.UserID).Error; err != nil {
+		context.JSON(http.StatusUnauthorized, gin.H{"message": "invalid refresh token"})
+		return
+	}
+
+	token, err := utils.GenerateJWT(user.ID, user.Email, session.ID)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"message": "Could not generate JWT token"})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{
+		"token":         token,
+		"refresh_token": newRefreshToken,
+		"expires_in":    int(utils.AccessTokenTTL.Seconds()),
+	})
+}
+
+func signOut(context *gin.Context) {
+	var request requestmodel.RefreshTokenRequest
+	if err := context.ShouldBindJSON(&request); err != nil || validate.Struct(request) != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"message": "refresh_token is required"})
+		return
+	}
+
+	err := models.RevokeSessionByRefreshToken(db.DB, request.RefreshToken)
+	if errors.Is(err, models.ErrInvalidRefreshToken) {
+		context.JSON(http.StatusUnauthorized, gin.H{"message": "invalid refresh token"})
+		return
+	}
+	if err != nil {
+		zap.S().Error("Failed to revoke session", zap.Error(err))
+		context.JSON(http.StatusInternalServerError, gin.H{"message": "Could not sign out"})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "User signed out successfully"})
 }
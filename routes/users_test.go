@@ -2,10 +2,14 @@ package routes
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
@@ -16,13 +20,27 @@ import (
 	"gorm.io/gorm"
 )
 
+func TestMain(m *testing.M) {
+	_, private, _ := ed25519.GenerateKey(rand.Reader)
+	key, _ := utils.NewSigningKey("test", private, time.Time{})
+	manager, _ := utils.NewKeyManager(key)
+	utils.UseSigningKeys(manager)
+
+	os.Exit(m.Run())
+}
+
 func SetupTestDB() *gorm.DB {
-	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	database, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
 	if err != nil {
 		panic("failed to connect to database")
 	}
-	db.AutoMigrate(&models.User{})
-	return db
+
+	// every connection to :memory: gets its own empty database
+	sqlDB, _ := database.DB()
+	sqlDB.SetMaxOpenConns(1)
+
+	db.Migrate(database)
+	return database
 }
 
 func SignUpMock(server *gin.Engine) *httptest.ResponseRecorder {
@@ -72,6 +90,11 @@ func TestSignUpRoute(t *testing.T) {
 	assert.Equal(t, "michael@tenkorang.com", createdUser.Email)
 	checkPassword := utils.ValidatePassword(createdUser.Password, "admin")
 	assert.True(t, checkPassword) // Ensure the password is not returned in plain text
+
+	var verification models.OutboxEmail
+	assert.NoError(t, db.DB.Where("\"to\" = ?", "michael@tenkorang.com").First(&verification).Error)
+	assert.Equal(t, "verify_email", verification.Template)
+	assert.Equal(t, models.OutboxPending, verification.Status)
 }
 
 func TestSignInRoute(t *testing.T) {
@@ -95,3 +118,153 @@ func TestSignInRoute(t *testing.T) {
 
 	assert.NotEmpty(t, signInResponse["token"])
 }
+
+func RefreshMock(server *gin.Engine, path, refreshToken string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]string{"refresh_token": refreshToken})
+	req, _ := http.NewRequest("POST", path, bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	responseWriter := httptest.NewRecorder()
+	server.ServeHTTP(responseWriter, req)
+
+	return responseWriter
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	db.DB = SetupTestDB()
+	server := gin.Default()
+	RegisterRoutes(server)
+
+	SignUpMock(server)
+	signInResponseWriter := SignInMock(server)
+	assert.Equal(t, http.StatusOK, signInResponseWriter.Code)
+
+	var signInResponse map[string]interface{}
+	json.Unmarshal(signInResponseWriter.Body.Bytes(), &signInResponse)
+	firstRefreshToken := signInResponse["refresh_token"].(string)
+
+	refreshResponseWriter := RefreshMock(server, "/token/refresh", firstRefreshToken)
+	assert.Equal(t, http.StatusOK, refreshResponseWriter.Code)
+
+	var refreshResponse map[string]interface{}
+	json.Unmarshal(refreshResponseWriter.Body.Bytes(), &refreshResponse)
+	assert.NotEmpty(t, refreshResponse["token"])
+	assert.NotEqual(t, firstRefreshToken, refreshResponse["refresh_token"])
+
+	// Replaying the first token kills the whole family, including the rotated token
+	reuseResponseWriter := RefreshMock(server, "/token/refresh", firstRefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, reuseResponseWriter.Code)
+
+	rotatedResponseWriter := RefreshMock(server, "/token/refresh", refreshResponse["refresh_token"].(string))
+	assert.Equal(t, http.StatusUnauthorized, rotatedResponseWriter.Code)
+}
+
+func TestSignOutRevokesSession(t *testing.T) {
+	db.DB = SetupTestDB()
+	server := gin.Default()
+	RegisterRoutes(server)
+
+	SignUpMock(server)
+	signInResponseWriter := SignInMock(server)
+
+	var signInResponse map[string]interface{}
+	json.Unmarshal(signInResponseWriter.Body.Bytes(), &signInResponse)
+	token := signInResponse["token"].(string)
+
+	signOutResponseWriter := RefreshMock(server, "/signout", signInResponse["refresh_token"].(string))
+	assert.Equal(t, http.StatusOK, signOutResponseWriter.Code)
+
+	req, _ := http.NewRequest("GET", "/1/1", nil)
+	req.Header.Set("Authorization", token)
+	responseWriter := httptest.NewRecorder()
+	server.ServeHTTP(responseWriter, req)
+
+	assert.Equal(t, http.StatusUnauthorized, responseWriter.Code)
+}
+
+func TestSignInFailuresLookAlike(t *testing.T) {
+	db.DB = SetupTestDB()
+	server := gin.Default()
+	RegisterRoutes(server)
+
+	models.NewUser("michael@tenkorang.com", "admin").Save(db.DB)
+
+	unknown := PostJSON(server, "/signin", gin.H{"email": "nobody@tenkorang.com", "password": "admin"})
+	wrong := PostJSON(server, "/signin", gin.H{"email": "michael@tenkorang.com", "password": "guess"})
+	assert.Equal(t, http.StatusUnauthorized, unknown.Code)
+	assert.Equal(t, unknown.Code, wrong.Code)
+	assert.Equal(t, unknown.Body.String(), wrong.Body.String())
+}
+
+func TestSignInSlowsDownAfterFailures(t *testing.T) {
+	db.DB = SetupTestDB()
+	server := gin.Default()
+	RegisterRoutes(server)
+
+	models.NewUser("michael@tenkorang.com", "admin").Save(db.DB)
+
+	// three failures are free, after that each one holds signins back
+	PostJSON(server, "/signin", gin.H{"email": "michael@tenkorang.com", "password": "guess"})
+	PostJSON(server, "/signin", gin.H{"email": "michael@tenkorang.com", "password": "guess"})
+	PostJSON(server, "/signin", gin.H{"email": "michael@tenkorang.com", "password": "guess"})
+	PostJSON(server, "/signin", gin.H{"email": "michael@tenkorang.com", "password": "guess"})
+
+	blocked := PostJSON(server, "/signin", gin.H{"email": "michael@tenkorang.com", "password": "admin"})
+	assert.Equal(t, http.StatusTooManyRequests, blocked.Code)
+	assert.Equal(t, "1", blocked.Header().Get("Retry-After"))
+}
+
+func TestSignInLockout(t *testing.T) {
+	db.DB = SetupTestDB()
+	server := gin.Default()
+	RegisterRoutes(server)
+
+	defaultPolicy := models.AccountSigninPolicy
+	models.AccountSigninPolicy = models.SigninPolicy{FreeFailures: 2, LockAfter: 2, LockFor: time.Minute}
+	defer func() { models.AccountSigninPolicy = defaultPolicy }()
+
+	user := models.NewUser("michael@tenkorang.com", "admin")
+	user.Save(db.DB)
+
+	PostJSON(server, "/signin", gin.H{"email": "michael@tenkorang.com", "password": "guess"})
+	PostJSON(server, "/signin", gin.H{"email": "michael@tenkorang.com", "password": "guess"})
+
+	locked := PostJSON(server, "/signin", gin.H{"email": "michael@tenkorang.com", "password": "admin"})
+	assert.Equal(t, http.StatusTooManyRequests, locked.Code)
+	assert.Equal(t, "60", locked.Header().Get("Retry-After"))
+
+	var event models.AuditEvent
+	assert.NoError(t, db.DB.Where("action = ?", models.AuditAccountLocked).First(&event).Error)
+	assert.Equal(t, user.ID, event.UserID)
+}
+
+func TestJWKSPublishesSigningKeys(t *testing.T) {
+	db.DB = SetupTestDB()
+	server := gin.Default()
+	RegisterRoutes(server)
+
+	req, _ := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+	responseWriter := httptest.NewRecorder()
+	server.ServeHTTP(responseWriter, req)
+	assert.Equal(t, http.StatusOK, responseWriter.Code)
+
+	var jwks struct {
+		Keys []utils.JWK `json:"keys"`
+	}
+	json.Unmarshal(responseWriter.Body.Bytes(), &jwks)
+	assert.Len(t, jwks.Keys, 1)
+	assert.Equal(t, "test", jwks.Keys[0].KeyID)
+	assert.Equal(t, "EdDSA", jwks.Keys[0].Algorithm)
+}
+
+func TestBadTokensAreUnauthorized(t *testing.T) {
+	db.DB = SetupTestDB()
+	server := gin.Default()
+	RegisterRoutes(server)
+
+	missing := AuthenticatedRequest(server, "GET", "/feed", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, missing.Code)
+
+	malformed := AuthenticatedRequest(server, "GET", "/feed", "not.a.token", nil)
+	assert.Equal(t, http.StatusUnauthorized, malformed.Code)
+	assert.JSONEq(t, `{"message": "token is malformed"}`, malformed.Body.String())
+}
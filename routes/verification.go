@@ -0,0 +1,107 @@
+package routes
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tenkorangjr/circle-app/db"
+	"github.com/tenkorangjr/circle-app/mail"
+	"github.com/tenkorangjr/circle-app/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	verificationTTL            = 24 * time.Hour
+	verificationResendCooldown = time.Minute
+)
+
+// sendVerificationEmail queues a fresh single-use verification link for
+// user, superseding any sent before.
+func sendVerificationEmail(db *gorm.DB, user *models.User) error {
+	token, err := models.IssueEmailVerification(db, user.ID, verificationTTL)
+	if err != nil {
+		return err
+	}
+
+	return mail.Queue(db, user.Email, "verify_email", gin.H{
+		"Email":     user.Email,
+		"Link":      tokenLink("/verify-email", token),
+		"ExpiresIn": "24 hours",
+	})
+}
+
+// tokenLink builds the link emailed to a user to hand a token back to path.
+func tokenLink(path, token string) string {
+	baseURL := os.Getenv("PUBLIC_BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+
+	return baseURL + path + "?token=" + url.QueryEscape(token)
+}
+
+// verifyEmail is where the link in the verification email leads.
+func verifyEmail(gc *gin.Context) {
+	token := gc.Query("token")
+	if token == "" {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": "token is required"})
+		return
+	}
+
+	user, err := models.VerifyEmail(db.DB, token)
+	if errors.Is(err, models.ErrInvalidVerificationToken) {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		zap.S().Error("Failed to verify email", zap.Error(err))
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "failed to verify email"})
+		return
+	}
+
+	if err := mail.Queue(db.DB, user.Email, "welcome", user); err != nil {
+		zap.S().Error("Failed to queue welcome email", zap.Error(err))
+	}
+
+	gc.JSON(http.StatusOK, gin.H{"message": "email verified"})
+}
+
+func resendVerificationEmail(gc *gin.Context) {
+	var user models.User
+	if err := db.DB.First(&user, gc.GetUint("userId")).Error; err != nil {
+		gc.JSON(http.StatusNotFound, gin.H{"message": "user not found"})
+		return
+	}
+
+	if user.EmailVerifiedAt != nil {
+		gc.JSON(http.StatusConflict, gin.H{"message": models.ErrEmailAlreadyVerified.Error()})
+		return
+	}
+
+	lastSent, err := models.LastVerificationSentAt(db.DB, user.ID)
+	if err != nil {
+		zap.S().Error("Failed to look up verification emails", zap.Error(err))
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "failed to resend verification email"})
+		return
+	}
+	if wait := verificationResendCooldown - time.Since(lastSent); wait > 0 {
+		gc.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		gc.JSON(http.StatusTooManyRequests, gin.H{"message": "a verification email was sent recently, try again shortly"})
+		return
+	}
+
+	if err := sendVerificationEmail(db.DB, &user); err != nil {
+		zap.S().Error("Failed to resend verification email", zap.Error(err))
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "failed to resend verification email"})
+		return
+	}
+
+	gc.JSON(http.StatusOK, gin.H{"message": "verification email sent"})
+}
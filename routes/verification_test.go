@@ -0,0 +1,109 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/tenkorangjr/circle-app/db"
+	"github.com/tenkorangjr/circle-app/models"
+)
+
+var verificationLink = regexp.MustCompile(`/verify-email\?token=\S+`)
+
+// LatestVerificationLink returns the path of the newest verification link
+// emailed to email.
+func LatestVerificationLink(t *testing.T, email string) string {
+	var outbox models.OutboxEmail
+	err := db.DB.Where("\"to\" = ? AND template = ?", email, "verify_email").
+		Order("id DESC").
+		First(&outbox).Error
+	if err != nil {
+		t.Fatalf("no verification email for %s: %v", email, err)
+	}
+	return verificationLink.FindString(outbox.Body)
+}
+
+func TestEmailVerification(t *testing.T) {
+	db.DB = SetupTestDB()
+	server := gin.Default()
+	RegisterRoutes(server)
+
+	userBytes, _ := json.Marshal(models.NewUser("new@circle.app", "admin"))
+	req, _ := http.NewRequest("POST", "/signup", bytes.NewBuffer(userBytes))
+	req.Header.Set("Content-Type", "application/json")
+	signUpResponse := httptest.NewRecorder()
+	server.ServeHTTP(signUpResponse, req)
+	assert.Equal(t, http.StatusCreated, signUpResponse.Code)
+
+	signInBytes, _ := json.Marshal(map[string]string{"email": "new@circle.app", "password": "admin"})
+	req, _ = http.NewRequest("POST", "/signin", bytes.NewBuffer(signInBytes))
+	req.Header.Set("Content-Type", "application/json")
+	signInResponse := httptest.NewRecorder()
+	server.ServeHTTP(signInResponse, req)
+
+	var signIn map[string]interface{}
+	json.Unmarshal(signInResponse.Body.Bytes(), &signIn)
+	assert.Equal(t, false, signIn["email_verified"])
+	token, _ := signIn["token"].(string)
+
+	// unverified accounts can't post or chat
+	postResponse := AuthenticatedRequest(server, "POST", "/posts", token, nil)
+	assert.Equal(t, http.StatusForbidden, postResponse.Code)
+	chatResponse := AuthenticatedRequest(server, "POST", "/chat/messages", token, gin.H{"to": "someone@circle.app", "body": "hi"})
+	assert.Equal(t, http.StatusForbidden, chatResponse.Code)
+
+	resendResponse := AuthenticatedRequest(server, "POST", "/verify-email/resend", token, nil)
+	assert.Equal(t, http.StatusTooManyRequests, resendResponse.Code)
+	assert.NotEmpty(t, resendResponse.Header().Get("Retry-After"))
+
+	link := LatestVerificationLink(t, "new@circle.app")
+	assert.NotEmpty(t, link)
+
+	verifyResponse := AuthenticatedRequest(server, "GET", link, "", nil)
+	assert.Equal(t, http.StatusOK, verifyResponse.Code)
+
+	// links work once
+	verifyResponse = AuthenticatedRequest(server, "GET", link, "", nil)
+	assert.Equal(t, http.StatusBadRequest, verifyResponse.Code)
+
+	chatResponse = AuthenticatedRequest(server, "POST", "/chat/messages", token, gin.H{"to": "someone@circle.app", "body": "hi"})
+	assert.NotEqual(t, http.StatusForbidden, chatResponse.Code)
+
+	resendResponse = AuthenticatedRequest(server, "POST", "/verify-email/resend", token, nil)
+	assert.Equal(t, http.StatusConflict, resendResponse.Code)
+
+	var welcome models.OutboxEmail
+	assert.NoError(t, db.DB.Where("\"to\" = ? AND template = ?", "new@circle.app", "welcome").First(&welcome).Error)
+}
+
+func TestResendReplacesVerificationLink(t *testing.T) {
+	db.DB = SetupTestDB()
+	server := gin.Default()
+	RegisterRoutes(server)
+
+	userId, token := SignUpAndSignInAs(server, "resend@circle.app")
+	db.DB.Model(&models.User{}).Where("id = ?", userId).Update("email_verified_at", nil)
+	first := LatestVerificationLink(t, "resend@circle.app")
+
+	// once the cooldown has passed a new link replaces the old one
+	db.DB.Model(&models.EmailVerification{}).Where("user_id = ?", userId).
+		Update("created_at", time.Now().Add(-2*verificationResendCooldown))
+	resendResponse := AuthenticatedRequest(server, "POST", "/verify-email/resend", token, nil)
+	assert.Equal(t, http.StatusOK, resendResponse.Code)
+
+	second := LatestVerificationLink(t, "resend@circle.app")
+	assert.NotEqual(t, first, second)
+
+	verifyResponse := AuthenticatedRequest(server, "GET", first, "", nil)
+	assert.Equal(t, http.StatusBadRequest, verifyResponse.Code)
+	verifyResponse = AuthenticatedRequest(server, "GET", second, "", nil)
+	assert.Equal(t, http.StatusOK, verifyResponse.Code)
+	assert.True(t, models.IsEmailVerified(db.DB, userId))
+}
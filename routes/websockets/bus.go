@@ -0,0 +1,83 @@
+package websockets
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+const (
+	// eventFrame pushes Frame to every connection of UserIDs
+	eventFrame = "frame"
+	// eventMessage pushes stored chat message MessageID to UserIDs
+	eventMessage = "message"
+	// eventPresence reports the sender's local status for some users
+	eventPresence = "presence"
+	// eventSync asks every instance to report all of its local statuses
+	eventSync = "sync"
+	// eventHeartbeat tells the others the sender is still alive
+	eventHeartbeat = "heartbeat"
+	// eventReconnected is raised locally by a bus that may have missed
+	// events while it was disconnected
+	eventReconnected = "reconnected"
+)
+
+// BusEvent is what instances tell each other about the users connected to
+// them. Which fields are set depends on Kind.
+type BusEvent struct {
+	Kind      string          `json:"kind"`
+	Instance  string          `json:"instance"`
+	UserIDs   []uint          `json:"user_ids,omitempty"`
+	Frame     json.RawMessage `json:"frame,omitempty"`
+	MessageID uint            `json:"message_id,omitempty"`
+	Presence  map[uint]string `json:"presence,omitempty"`
+}
+
+// Bus carries events between every instance serving /chat, so that a user
+// connected to any of them can be reached from all of them.
+type Bus interface {
+	// Publish sends the event to every subscriber, this instance included.
+	Publish(ctx context.Context, event BusEvent) error
+	// Subscribe calls handle for every event published from now on.
+	Subscribe(handle func(BusEvent)) error
+	Close() error
+}
+
+// MemoryBus connects subscribers within a single process. It is all a lone
+// instance needs.
+type MemoryBus struct {
+	mutex    sync.RWMutex
+	handlers []func(BusEvent)
+}
+
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{}
+}
+
+func (b *MemoryBus) Publish(ctx context.Context, event BusEvent) error {
+	b.mutex.RLock()
+	handlers := make([]func(BusEvent), len(b.handlers))
+	copy(handlers, b.handlers)
+	b.mutex.RUnlock()
+
+	for _, handle := range handlers {
+		handle(event)
+	}
+	return nil
+}
+
+func (b *MemoryBus) Subscribe(handle func(BusEvent)) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.handlers = append(b.handlers, handle)
+	return nil
+}
+
+func (b *MemoryBus) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.handlers = nil
+	return nil
+}
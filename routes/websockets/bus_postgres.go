@@ -0,0 +1,145 @@
+package websockets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// NOTIFY payloads must be shorter than 8000 bytes
+const maxNotifyPayload = 7999
+
+const (
+	listenRetryMin = time.Second
+	listenRetryMax = 30 * time.Second
+)
+
+var ErrEventTooLarge = errors.New("event is too large for the bus")
+
+// PostgresBus passes events between instances with LISTEN/NOTIFY on the
+// application database. Events are published through the regular connection
+// pool and received on a dedicated connection, which is re-established if it
+// drops.
+type PostgresBus struct {
+	db      *gorm.DB
+	dsn     string
+	channel string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewPostgresBus(db *gorm.DB, dsn, channel string) *PostgresBus {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &PostgresBus{
+		db:      db,
+		dsn:     dsn,
+		channel: channel,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+func (b *PostgresBus) Publish(ctx context.Context, event BusEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if len(payload) > maxNotifyPayload {
+		return ErrEventTooLarge
+	}
+
+	return b.db.WithContext(ctx).Exec("SELECT pg_notify(?, ?)", b.channel, string(payload)).Error
+}
+
+// Subscribe opens the listening connection before returning, so a bad DSN
+// is reported straight away.
+func (b *PostgresBus) Subscribe(handle func(BusEvent)) error {
+	conn, err := b.listen()
+	if err != nil {
+		return err
+	}
+
+	go b.receive(conn, handle)
+	return nil
+}
+
+func (b *PostgresBus) Close() error {
+	b.cancel()
+	return nil
+}
+
+func (b *PostgresBus) listen() (*pgx.Conn, error) {
+	conn, err := pgx.Connect(b.ctx, b.dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.Exec(b.ctx, "LISTEN "+pgx.Identifier{b.channel}.Sanitize()); err != nil {
+		conn.Close(context.Background())
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func (b *PostgresBus) receive(conn *pgx.Conn, handle func(BusEvent)) {
+	defer func() {
+		if conn != nil {
+			conn.Close(context.Background())
+		}
+	}()
+
+	for {
+		notification, err := conn.WaitForNotification(b.ctx)
+		if err == nil {
+			var event BusEvent
+			if err := json.Unmarshal([]byte(notification.Payload), &event); err != nil {
+				zap.S().Errorf("ignoring malformed bus event: %v", err)
+				continue
+			}
+			handle(event)
+			continue
+		}
+
+		if b.ctx.Err() != nil {
+			return
+		}
+
+		zap.S().Errorf("lost bus connection: %v", err)
+		conn.Close(context.Background())
+		conn = b.reconnect()
+		if conn == nil {
+			return
+		}
+		handle(BusEvent{Kind: eventReconnected})
+	}
+}
+
+// reconnect retries with exponential backoff until it has a new listening
+// connection, or returns nil once the bus is closed.
+func (b *PostgresBus) reconnect() *pgx.Conn {
+	delay := listenRetryMin
+	for {
+		select {
+		case <-time.After(delay):
+		case <-b.ctx.Done():
+			return nil
+		}
+
+		conn, err := b.listen()
+		if err == nil {
+			zap.S().Info("bus connection re-established")
+			return conn
+		}
+
+		zap.S().Errorf("failed to re-establish bus connection: %v", err)
+		delay = min(delay*2, listenRetryMax)
+	}
+}
@@ -0,0 +1,298 @@
+package websockets
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tenkorangjr/circle-app/db"
+	"github.com/tenkorangjr/circle-app/models"
+	"go.uber.org/zap"
+)
+
+const (
+	// how often each instance tells the others it is alive
+	heartbeatInterval = 10 * time.Second
+	// an instance not heard from for this long is presumed gone, along with
+	// its connections
+	instanceTimeout = 3 * heartbeatInterval
+	// users per presence event when answering a sync
+	syncBatchSize  = 200
+	publishTimeout = 5 * time.Second
+)
+
+// instanceID tells this process's events apart from everyone else's.
+var instanceID = randomID()
+
+// bus is nil until UseBus is called, in which case this instance works on
+// its own.
+var bus Bus
+
+var registry = newPresenceRegistry()
+
+func randomID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// InitBus connects this instance to the others through the bus named by
+// CHAT_BUS: "memory" (the default) when running a single instance, or
+// "postgres" to use LISTEN/NOTIFY on the application database.
+func InitBus() {
+	var b Bus
+	switch backend := os.Getenv("CHAT_BUS"); backend {
+	case "", "memory":
+		b = NewMemoryBus()
+	case "postgres":
+		channel := os.Getenv("CHAT_BUS_CHANNEL")
+		if channel == "" {
+			channel = "circle_chat"
+		}
+		b = NewPostgresBus(db.DB, db.DSN(), channel)
+	default:
+		log.Fatalf("Unknown CHAT_BUS %q", backend)
+	}
+
+	if err := UseBus(b); err != nil {
+		log.Fatalf("Failed to connect to the chat bus: %v", err)
+	}
+}
+
+// UseBus subscribes this instance to b and asks the other instances who is
+// connected to them. It must be called once, before the server starts.
+func UseBus(b Bus) error {
+	if err := b.Subscribe(handleBusEvent); err != nil {
+		return err
+	}
+	bus = b
+
+	publish(BusEvent{Kind: eventSync})
+	go heartbeat()
+	return nil
+}
+
+// publish sends an event from this instance and reports whether it went out.
+func publish(event BusEvent) bool {
+	if bus == nil {
+		return false
+	}
+
+	event.Instance = instanceID
+
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+
+	if err := bus.Publish(ctx, event); err != nil {
+		zap.S().Errorf("failed to publish %s event: %v", event.Kind, err)
+		return false
+	}
+	return true
+}
+
+func heartbeat() {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		publish(BusEvent{Kind: eventHeartbeat})
+
+		for _, userId := range registry.prune(time.Now().Add(-instanceTimeout)) {
+			presence.announce(userId, false)
+		}
+	}
+}
+
+func handleBusEvent(event BusEvent) {
+	if event.Kind == eventReconnected {
+		// whatever happened meanwhile is lost, so start over
+		publish(BusEvent{Kind: eventSync})
+		return
+	}
+	if event.Instance == instanceID {
+		return
+	}
+
+	registry.touch(event.Instance, time.Now())
+
+	switch event.Kind {
+	case eventFrame:
+		for _, userId := range event.UserIDs {
+			for _, client := range WSManager.GetClients(userId) {
+				client.send(Outbound{Frame: event.Frame})
+			}
+		}
+	case eventMessage:
+		var chatMessage models.ChatMessage
+		if err := db.DB.First(&chatMessage, event.MessageID).Error; err != nil {
+			zap.S().Errorf("failed to load message %d from the bus: %v", event.MessageID, err)
+			return
+		}
+
+		var clients []*Client
+		for _, userId := range event.UserIDs {
+			clients = append(clients, WSManager.GetClients(userId)...)
+		}
+		if _, err := mRouter.deliver(clients, &chatMessage); err != nil {
+			zap.S().Errorf("failed to deliver message %d from the bus: %v", event.MessageID, err)
+		}
+	case eventPresence:
+		for _, userId := range registry.update(event.Instance, event.Presence) {
+			presence.announce(userId, false)
+		}
+	case eventSync:
+		publishSnapshot()
+	}
+}
+
+// publishSnapshot reports the status of everyone connected here.
+func publishSnapshot() {
+	statuses := make(map[uint]string)
+	for _, userId := range WSManager.Users() {
+		statuses[userId] = WSManager.Status(userId)
+		if len(statuses) == syncBatchSize {
+			publish(BusEvent{Kind: eventPresence, Presence: statuses})
+			statuses = make(map[uint]string)
+		}
+	}
+
+	if len(statuses) > 0 {
+		publish(BusEvent{Kind: eventPresence, Presence: statuses})
+	}
+}
+
+// pushFrame sends a frame to every connection userIds have, whether it is
+// open on this instance or another one.
+func pushFrame(userIds []uint, frameType, id string, data interface{}) {
+	frame, err := newFrame(frameType, id, data)
+	if err != nil {
+		zap.S().Errorf("failed to encode %s frame: %v", frameType, err)
+		return
+	}
+
+	var remote []uint
+	for _, userId := range userIds {
+		for _, client := range WSManager.GetClients(userId) {
+			client.send(Outbound{Frame: frame})
+		}
+		if registry.isOnline(userId) {
+			remote = append(remote, userId)
+		}
+	}
+
+	if len(remote) > 0 {
+		publish(BusEvent{Kind: eventFrame, UserIDs: remote, Frame: frame})
+	}
+}
+
+// clusterStatus is userId's presence across every instance.
+func clusterStatus(userId uint) string {
+	return mergeStatus(WSManager.Status(userId), registry.status(userId))
+}
+
+func mergeStatus(a, b string) string {
+	if a == PresenceOnline || b == PresenceOnline {
+		return PresenceOnline
+	}
+	if a == PresenceAway || b == PresenceAway {
+		return PresenceAway
+	}
+	return PresenceOffline
+}
+
+// presenceRegistry holds what the other instances last reported about the
+// users connected to them. Users that are offline there are left out.
+type presenceRegistry struct {
+	mutex     sync.Mutex
+	instances map[string]map[uint]string
+	heardAt   map[string]time.Time
+}
+
+func newPresenceRegistry() *presenceRegistry {
+	return &presenceRegistry{
+		instances: make(map[string]map[uint]string),
+		heardAt:   make(map[string]time.Time),
+	}
+}
+
+func (r *presenceRegistry) touch(instance string, at time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.heardAt[instance] = at
+}
+
+// update records statuses reported by instance and returns the users whose
+// status there changed.
+func (r *presenceRegistry) update(instance string, statuses map[uint]string) []uint {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	users := r.instances[instance]
+	if users == nil {
+		users = make(map[uint]string)
+		r.instances[instance] = users
+	}
+
+	var changed []uint
+	for userId, status := range statuses {
+		previous, ok := users[userId]
+		if !ok {
+			previous = PresenceOffline
+		}
+		if status == PresenceOffline {
+			delete(users, userId)
+		} else {
+			users[userId] = status
+		}
+		if status != previous {
+			changed = append(changed, userId)
+		}
+	}
+	return changed
+}
+
+// prune forgets instances not heard from since cutoff and returns the users
+// that were connected to them.
+func (r *presenceRegistry) prune(cutoff time.Time) []uint {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var affected []uint
+	for instance, heardAt := range r.heardAt {
+		if heardAt.After(cutoff) {
+			continue
+		}
+		zap.S().Warnf("chat instance %s stopped responding", instance)
+		for userId := range r.instances[instance] {
+			affected = append(affected, userId)
+		}
+		delete(r.instances, instance)
+		delete(r.heardAt, instance)
+	}
+	return affected
+}
+
+func (r *presenceRegistry) status(userId uint) string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	status := PresenceOffline
+	for _, users := range r.instances {
+		if s, ok := users[userId]; ok {
+			status = mergeStatus(status, s)
+		}
+	}
+	return status
+}
+
+// isOnline reports whether userId has a connection on another instance.
+func (r *presenceRegistry) isOnline(userId uint) bool {
+	return r.status(userId) != PresenceOffline
+}
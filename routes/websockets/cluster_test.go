@@ -0,0 +1,45 @@
+package websockets
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryMergesAndPrunesInstances(t *testing.T) {
+	registry := newPresenceRegistry()
+	now := time.Now()
+
+	registry.touch("a", now)
+	registry.touch("b", now.Add(-time.Minute))
+	assert.ElementsMatch(t, []uint{1, 2}, registry.update("a", map[uint]string{1: PresenceAway, 2: PresenceOnline}))
+	assert.Equal(t, []uint{1}, registry.update("b", map[uint]string{1: PresenceOnline}))
+	assert.Empty(t, registry.update("a", map[uint]string{1: PresenceAway}))
+
+	assert.Equal(t, PresenceOnline, registry.status(1))
+	assert.Equal(t, PresenceOffline, registry.status(3))
+
+	// b has gone quiet, so its users go with it
+	assert.Equal(t, []uint{1}, registry.prune(now.Add(-instanceTimeout)))
+	assert.Equal(t, PresenceAway, registry.status(1))
+
+	assert.Equal(t, []uint{2}, registry.update("a", map[uint]string{2: PresenceOffline}))
+	assert.False(t, registry.isOnline(2))
+}
+
+func TestBusFramesReachLocalConnections(t *testing.T) {
+	client := WSManager.AddClient(99, nil)
+	defer WSManager.RemoveClient(client)
+
+	bus := NewMemoryBus()
+	bus.Subscribe(handleBusEvent)
+
+	// events this instance published itself were already handled locally
+	bus.Publish(context.Background(), BusEvent{Kind: eventFrame, Instance: instanceID, UserIDs: []uint{99}, Frame: []byte(`{}`)})
+	assert.Empty(t, client.SendChan)
+
+	bus.Publish(context.Background(), BusEvent{Kind: eventFrame, Instance: "other", UserIDs: []uint{99}, Frame: []byte(`{"v":1}`)})
+	assert.Equal(t, `{"v":1}`, string((<-client.SendChan).Frame))
+}
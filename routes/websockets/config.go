@@ -0,0 +1,136 @@
+package websockets
+
+import (
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Config controls keepalives and limits for /chat connections.
+type Config struct {
+	// WriteWait bounds how long a single frame may take to write.
+	WriteWait time.Duration
+	// PongWait is how long the peer may stay silent before it is considered
+	// dead. Any frame or pong from it resets the clock.
+	PongWait time.Duration
+	// PingPeriod is how often the server pings; it must be below PongWait so
+	// a healthy peer always has a chance to answer in time.
+	PingPeriod time.Duration
+	// MaxMessageSize is the largest frame, in bytes, a client may send.
+	MaxMessageSize int64
+	// SendBufferSize is how many outgoing frames may wait for a connection
+	// before it is treated as a slow consumer.
+	SendBufferSize int
+	// SlowConsumerPolicy decides what happens to a connection whose send
+	// buffer is full: SlowConsumerQueue or SlowConsumerDisconnect.
+	SlowConsumerPolicy string
+	// StreamRetention is how long an event stream is kept after its request
+	// ends, for the client to resume it with Last-Event-ID.
+	StreamRetention time.Duration
+}
+
+const (
+	// SlowConsumerQueue drops frames that don't fit. Chat messages among them
+	// stay undelivered and are replayed once the connection catches up.
+	SlowConsumerQueue = "queue"
+	// SlowConsumerDisconnect closes the connection on the first dropped
+	// frame. Undelivered messages are replayed when the client reconnects.
+	SlowConsumerDisconnect = "disconnect"
+)
+
+func DefaultConfig() Config {
+	return Config{
+		WriteWait:          10 * time.Second,
+		PongWait:           60 * time.Second,
+		PingPeriod:         54 * time.Second,
+		MaxMessageSize:     8 * 1024,
+		SendBufferSize:     256,
+		SlowConsumerPolicy: SlowConsumerQueue,
+		StreamRetention:    time.Minute,
+	}
+}
+
+// ConfigFromEnv starts from DefaultConfig and overrides it with
+// WS_WRITE_WAIT, WS_PONG_WAIT, WS_PING_PERIOD, WS_STREAM_RETENTION (Go
+// durations),
+// WS_MAX_MESSAGE_SIZE (bytes), WS_SEND_BUFFER_SIZE (frames) and
+// WS_SLOW_CONSUMER_POLICY where set.
+func ConfigFromEnv() Config {
+	c := DefaultConfig()
+
+	durations := map[string]*time.Duration{
+		"WS_WRITE_WAIT":       &c.WriteWait,
+		"WS_PONG_WAIT":        &c.PongWait,
+		"WS_PING_PERIOD":      &c.PingPeriod,
+		"WS_STREAM_RETENTION": &c.StreamRetention,
+	}
+	for key, target := range durations {
+		raw := os.Getenv(key)
+		if raw == "" {
+			continue
+		}
+		value, err := time.ParseDuration(raw)
+		if err != nil || value <= 0 {
+			zap.S().Warnf("ignoring invalid %s=%q", key, raw)
+			continue
+		}
+		*target = value
+	}
+
+	if raw := os.Getenv("WS_MAX_MESSAGE_SIZE"); raw != "" {
+		value, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || value <= 0 {
+			zap.S().Warnf("ignoring invalid WS_MAX_MESSAGE_SIZE=%q", raw)
+		} else {
+			c.MaxMessageSize = value
+		}
+	}
+
+	if raw := os.Getenv("WS_SEND_BUFFER_SIZE"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value <= 0 {
+			zap.S().Warnf("ignoring invalid WS_SEND_BUFFER_SIZE=%q", raw)
+		} else {
+			c.SendBufferSize = value
+		}
+	}
+
+	switch raw := os.Getenv("WS_SLOW_CONSUMER_POLICY"); raw {
+	case "":
+	case SlowConsumerQueue, SlowConsumerDisconnect:
+		c.SlowConsumerPolicy = raw
+	default:
+		zap.S().Warnf("ignoring invalid WS_SLOW_CONSUMER_POLICY=%q", raw)
+	}
+
+	return c
+}
+
+var config atomic.Pointer[Config]
+
+func init() {
+	Configure(DefaultConfig())
+}
+
+// Configure replaces the connection settings. Connections pick them up when
+// they are opened. A PingPeriod that is not below PongWait is pulled down to
+// 90% of it, and an unset send buffer, policy or retention falls back to the
+// default.
+func Configure(c Config) {
+	if c.PingPeriod >= c.PongWait {
+		c.PingPeriod = c.PongWait * 9 / 10
+	}
+	if c.SendBufferSize <= 0 {
+		c.SendBufferSize = DefaultConfig().SendBufferSize
+	}
+	if c.SlowConsumerPolicy == "" {
+		c.SlowConsumerPolicy = SlowConsumerQueue
+	}
+	if c.StreamRetention <= 0 {
+		c.StreamRetention = DefaultConfig().StreamRetention
+	}
+	config.Store(&c)
+}
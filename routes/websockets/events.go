@@ -0,0 +1,340 @@
+package websockets
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	// events kept per stream after they are written, for replay to a client
+	// that reconnects having missed them
+	replayBufferSize  = 100
+	maxStreamsPerUser = 5
+)
+
+var errTooManyStreams = errors.New("too many open event streams")
+
+type streamEvent struct {
+	seq  uint64
+	kind string
+	out  Outbound
+}
+
+// eventStream is what an SSE client has instead of a websocket. Its Client
+// is registered like any other, so it is sent everything a socket would be,
+// and it outlives the request for StreamRetention so that a client coming
+// back with Last-Event-ID picks up where it left off.
+type eventStream struct {
+	id       string
+	client   *Client
+	openedAt time.Time
+
+	// writer is held by the request currently streaming
+	writer sync.Mutex
+
+	mutex    sync.Mutex
+	seq      uint64
+	replay   []streamEvent
+	attached chan struct{}
+	expiry   *time.Timer
+	closed   bool
+}
+
+// attach hands the stream to a new request, taking it from any request that
+// still has it. The returned channel is closed if another request takes it in
+// turn, and release must be called once the request is done with it.
+func (s *eventStream) attach() (<-chan struct{}, func(), bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil, nil, false
+	}
+	if s.attached != nil {
+		close(s.attached)
+	}
+	if s.expiry != nil {
+		s.expiry.Stop()
+		s.expiry = nil
+	}
+
+	taken := make(chan struct{})
+	s.attached = taken
+
+	release := func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		if s.attached != taken {
+			return
+		}
+		s.attached = nil
+		s.expiry = time.AfterFunc(s.client.config.StreamRetention, s.expire)
+	}
+	return taken, release, true
+}
+
+func (s *eventStream) isAttached() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.attached != nil
+}
+
+func (s *eventStream) expire() {
+	s.mutex.Lock()
+	if s.attached != nil || s.closed {
+		s.mutex.Unlock()
+		return
+	}
+	s.mutex.Unlock()
+
+	streams.close(s)
+}
+
+// record numbers a frame that is about to be written and keeps it for
+// replay, forgetting the oldest once the buffer is full.
+func (s *eventStream) record(out Outbound) streamEvent {
+	var envelope Envelope
+	json.Unmarshal(out.Frame, &envelope)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.seq++
+	event := streamEvent{seq: s.seq, kind: envelope.Type, out: out}
+	s.replay = append(s.replay, event)
+	if len(s.replay) > replayBufferSize {
+		s.replay = s.replay[len(s.replay)-replayBufferSize:]
+	}
+	return event
+}
+
+// since returns the buffered events after seq, oldest first.
+func (s *eventStream) since(seq uint64) []streamEvent {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var events []streamEvent
+	for _, event := range s.replay {
+		if event.seq > seq {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func (s *eventStream) eventID(seq uint64) string {
+	return s.id + ":" + strconv.FormatUint(seq, 10)
+}
+
+type streamRegistry struct {
+	mutex   sync.Mutex
+	streams map[string]*eventStream
+}
+
+var streams = &streamRegistry{
+	streams: make(map[string]*eventStream),
+}
+
+// resume finds the stream a Last-Event-ID of userId's points into, along
+// with the last event the client saw.
+func (r *streamRegistry) resume(userId uint, lastEventId string) (*eventStream, uint64) {
+	id, rawSeq, ok := strings.Cut(lastEventId, ":")
+	if !ok {
+		return nil, 0
+	}
+	seq, err := strconv.ParseUint(rawSeq, 10, 64)
+	if err != nil {
+		return nil, 0
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	stream, ok := r.streams[id]
+	if !ok || stream.client.Id != userId {
+		return nil, 0
+	}
+	return stream, seq
+}
+
+// open starts a new stream for userId, making room by closing their oldest
+// detached stream if they already have as many as allowed.
+func (r *streamRegistry) open(userId uint) (*eventStream, error) {
+	r.mutex.Lock()
+	var owned []*eventStream
+	for _, stream := range r.streams {
+		if stream.client.Id == userId {
+			owned = append(owned, stream)
+		}
+	}
+
+	var evict *eventStream
+	if len(owned) >= maxStreamsPerUser {
+		for _, stream := range owned {
+			if !stream.isAttached() && (evict == nil || stream.openedAt.Before(evict.openedAt)) {
+				evict = stream
+			}
+		}
+		if evict == nil {
+			r.mutex.Unlock()
+			return nil, errTooManyStreams
+		}
+	}
+
+	stream := &eventStream{
+		id:       randomID(),
+		client:   WSManager.AddClient(userId, nil),
+		openedAt: time.Now(),
+	}
+	r.streams[stream.id] = stream
+	r.mutex.Unlock()
+
+	if evict != nil {
+		r.close(evict)
+	}
+	return stream, nil
+}
+
+// close drops a stream for good, taking its client offline.
+func (r *streamRegistry) close(stream *eventStream) {
+	r.mutex.Lock()
+	if r.streams[stream.id] != stream {
+		r.mutex.Unlock()
+		return
+	}
+	delete(r.streams, stream.id)
+	r.mutex.Unlock()
+
+	stream.mutex.Lock()
+	stream.closed = true
+	if stream.attached != nil {
+		close(stream.attached)
+		stream.attached = nil
+	}
+	stream.mutex.Unlock()
+
+	close(stream.client.done)
+	WSManager.RemoveClient(stream.client)
+	presence.refresh(stream.client.Id)
+}
+
+// HandleEvents serves GET /events, a Server-Sent Events stream of the same
+// frames /chat would send, for clients that cannot open a websocket. Each
+// event's data is a frame envelope and its type the frame's type. Sending a
+// received event ID back as Last-Event-ID resumes the stream, replaying what
+// the client missed while it was away.
+func HandleEvents(gc *gin.Context) {
+	userId := gc.GetUint("userId")
+
+	stream, lastSeq := streams.resume(userId, gc.GetHeader("Last-Event-ID"))
+	var taken <-chan struct{}
+	var release func()
+	ok := false
+	if stream != nil {
+		taken, release, ok = stream.attach()
+	}
+
+	if !ok {
+		var err error
+		stream, err = streams.open(userId)
+		if err != nil {
+			gc.JSON(http.StatusTooManyRequests, gin.H{"message": err.Error()})
+			return
+		}
+		lastSeq = 0
+		taken, release, _ = stream.attach()
+
+		zap.S().Infof("User %d opened an event stream", userId)
+		presence.refresh(userId)
+		go func() {
+			if err := mRouter.DeliverPending(stream.client); err != nil {
+				zap.S().Errorf("failed to deliver pending messages: %v", err)
+			}
+		}()
+	}
+	defer release()
+
+	// wait for any request that had the stream to let go of it
+	stream.writer.Lock()
+	defer stream.writer.Unlock()
+
+	client := stream.client
+	gc.Header("Content-Type", "text/event-stream")
+	gc.Header("Cache-Control", "no-cache")
+	gc.Header("Connection", "keep-alive")
+	gc.Header("X-Accel-Buffering", "no")
+	gc.Status(http.StatusOK)
+	gc.Writer.Flush()
+
+	for _, event := range stream.since(lastSeq) {
+		if err := writeStreamEvent(gc, stream, event); err != nil {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(client.config.PingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case out := <-client.SendChan:
+			if err := writeStreamEvent(gc, stream, stream.record(out)); err != nil {
+				return
+			}
+
+			if len(client.SendChan) == 0 && client.catchUp() {
+				go func() {
+					if err := mRouter.DeliverPending(client); err != nil {
+						zap.S().Errorf("failed to replay dropped messages: %v", err)
+					}
+				}()
+			}
+		case <-ticker.C:
+			// a comment line keeps proxies from timing the stream out
+			if _, err := gc.Writer.WriteString(": ping\n\n"); err != nil {
+				return
+			}
+			gc.Writer.Flush()
+		case <-client.evicted:
+			streams.close(stream)
+			return
+		case <-taken:
+			return
+		case <-gc.Request.Context().Done():
+			return
+		}
+	}
+}
+
+// writeStreamEvent sends one event and, if it carries a chat message,
+// records the delivery just like writePump does.
+func writeStreamEvent(gc *gin.Context, stream *eventStream, event streamEvent) error {
+	err := sse.Encode(gc.Writer, sse.Event{
+		Id:    stream.eventID(event.seq),
+		Event: event.kind,
+		Data:  string(event.out.Frame),
+	})
+	if err != nil {
+		return err
+	}
+	gc.Writer.Flush()
+
+	if event.out.MessageID != 0 {
+		if err := mRouter.ConfirmDelivery(event.out.MessageID, event.out.SenderID); err != nil {
+			zap.S().Errorf("failed to record delivery of message %d: %v", event.out.MessageID, err)
+		}
+		stream.client.forget(event.out.MessageID)
+	}
+	return nil
+}
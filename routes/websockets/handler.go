@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
@@ -13,6 +14,12 @@ import (
 	"go.uber.org/zap"
 )
 
+const (
+	// frames a client may send per second, and how many it can burst
+	frameRate  = 10
+	frameBurst = 20
+)
+
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true
@@ -21,29 +28,208 @@ var upgrader = websocket.Upgrader{
 var mRouter = MessageRouter{}
 var validate = validator.New(validator.WithRequiredStructEnabled())
 
-type Message struct {
-	To  string `validate:"required,email" json:"to"`
-	Msg string `validate:"required" json:"msg"`
-}
+var (
+	ErrUnknownRecipient = errors.New("no such email in database")
+	ErrNotRoomMember    = errors.New("not a member of this room")
+	ErrInvalidAddress   = errors.New("message must be addressed to exactly one of to or room")
+)
 
 type MessageRouter struct{}
 
-func (m *MessageRouter) RouteMessage(msg Message) error {
+// RouteMessage stores the message and pushes it to the receiver wherever
+// they are connected. Otherwise it stays queued until their next connection,
+// which is reported back so the sender can tell.
+func (m *MessageRouter) RouteMessage(senderId uint, msg SendMessagePayload) (*models.ChatMessage, bool, error) {
 	var receiver models.User
 	if err := db.DB.Where("email = ?", msg.To).First(&receiver).Error; err != nil {
-		return errors.New("no such email in database")
+		return nil, false, ErrUnknownRecipient
+	}
+
+	chatMessage := models.NewChatMessage(senderId, receiver.ID, msg.Body)
+	if err := db.DB.Create(chatMessage).Error; err != nil {
+		return nil, false, err
+	}
+
+	pushed, err := m.push(chatMessage, []uint{receiver.ID})
+	if err != nil {
+		return nil, false, err
+	}
+	if !pushed {
+		zap.S().Infof("Receiver (%d) unavailable, message %d queued", receiver.ID, chatMessage.ID)
+	}
+	return chatMessage, !pushed, nil
+}
+
+// RouteRoomMessage stores a message sent to a room and pushes it to every
+// other member that is currently connected. Members that are too far behind
+// miss the push and have to page the room's history.
+func (m *MessageRouter) RouteRoomMessage(senderId, roomId uint, body string) (*models.ChatMessage, error) {
+	if !models.IsRoomMember(db.DB, roomId, senderId) {
+		return nil, ErrNotRoomMember
+	}
+
+	memberIds, err := models.RoomMemberIDs(db.DB, roomId)
+	if err != nil {
+		return nil, err
 	}
 
-	receiverClient, ok := WSManager.GetClient(receiver.ID)
-	if !ok {
-		zap.S().Infof("Receiver (%d) currently inactive", receiver.ID)
-		return errors.New("receiver is currently inactive")
+	chatMessage := models.NewRoomMessage(senderId, roomId, body)
+	if err := db.DB.Create(chatMessage).Error; err != nil {
+		return nil, err
+	}
+
+	recipients := make([]uint, 0, len(memberIds))
+	for _, memberId := range memberIds {
+		if memberId != senderId {
+			recipients = append(recipients, memberId)
+		}
+	}
+
+	if _, err := m.push(chatMessage, recipients); err != nil {
+		return nil, err
+	}
+	return chatMessage, nil
+}
+
+// push hands a stored message to every connection of userIds, on this
+// instance or through the bus to another, and reports whether any of them
+// could take it now.
+func (m *MessageRouter) push(chatMessage *models.ChatMessage, userIds []uint) (bool, error) {
+	var clients []*Client
+	var remote []uint
+	for _, userId := range userIds {
+		clients = append(clients, WSManager.GetClients(userId)...)
+		if registry.isOnline(userId) {
+			remote = append(remote, userId)
+		}
+	}
+
+	pushed, err := m.deliver(clients, chatMessage)
+	if err != nil {
+		return false, err
+	}
+
+	if len(remote) > 0 && publish(BusEvent{Kind: eventMessage, UserIDs: remote, MessageID: chatMessage.ID}) {
+		pushed = true
+	}
+	return pushed, nil
+}
+
+// DeliverPending flushes messages that arrived while the client's user was
+// offline.
+func (m *MessageRouter) DeliverPending(client *Client) error {
+	messages, err := models.UndeliveredMessages(db.DB, client.Id)
+	if err != nil {
+		return err
+	}
+
+	for i := range messages {
+		if _, err := m.deliver([]*Client{client}, &messages[i]); err != nil {
+			return err
+		}
 	}
 
-	receiverClient.SendChan <- []byte(msg.Msg)
 	return nil
 }
 
+// deliver fans a message out to the given connections on this instance and
+// reports whether any of them took it. A direct message is marked delivered
+// by writePump once one of them actually writes it; room messages are not
+// tracked per member.
+func (m *MessageRouter) deliver(clients []*Client, chatMessage *models.ChatMessage) (bool, error) {
+	if len(clients) == 0 {
+		return false, nil
+	}
+
+	payload := MessagePayload{
+		ID:     chatMessage.ID,
+		From:   chatMessage.SenderID,
+		Body:   chatMessage.Body,
+		SentAt: chatMessage.CreatedAt,
+	}
+	if chatMessage.RoomID != nil {
+		payload.Room = *chatMessage.RoomID
+	}
+
+	frame, err := newFrame(FrameMessage, "", payload)
+	if err != nil {
+		return false, err
+	}
+
+	pushed := false
+	for _, client := range clients {
+		var ok bool
+		if chatMessage.RoomID != nil {
+			ok = client.send(Outbound{Frame: frame})
+		} else {
+			ok = client.sendMessage(chatMessage, frame)
+		}
+		pushed = pushed || ok
+	}
+	return pushed, nil
+}
+
+// SendMessage routes a message from senderId to a user or a room, whichever
+// it is addressed to, and reports whether it was queued rather than pushed
+// straight to the recipient. Message frames and POST /chat/messages both
+// come through here.
+func SendMessage(senderId uint, payload SendMessagePayload) (*models.ChatMessage, bool, error) {
+	if (payload.To == "") == (payload.Room == 0) {
+		return nil, false, ErrInvalidAddress
+	}
+
+	if payload.Room != 0 {
+		chatMessage, err := mRouter.RouteRoomMessage(senderId, payload.Room, payload.Body)
+		return chatMessage, false, err
+	}
+	return mRouter.RouteMessage(senderId, payload)
+}
+
+// ConfirmDelivery records that a message frame reached the receiver's device
+// and lets the sender know.
+func (m *MessageRouter) ConfirmDelivery(messageId, senderId uint) error {
+	chatMessage := models.ChatMessage{SenderID: senderId}
+	chatMessage.ID = messageId
+
+	changed, err := chatMessage.MarkDelivered(db.DB)
+	if err != nil || !changed {
+		return err
+	}
+
+	m.sendReceipt(&chatMessage, *chatMessage.DeliveredAt)
+	return nil
+}
+
+// MarkRead records that readerId has read the given messages and lets their
+// senders know.
+func (m *MessageRouter) MarkRead(readerId uint, messageIds []uint) error {
+	messages, err := models.MarkRead(db.DB, readerId, messageIds)
+	if err != nil {
+		return err
+	}
+
+	for i := range messages {
+		m.sendReceipt(&messages[i], *messages[i].ReadAt)
+	}
+	return nil
+}
+
+func (m *MessageRouter) sendReceipt(chatMessage *models.ChatMessage, at time.Time) {
+	receipt := ReceiptPayload{
+		MessageID: chatMessage.ID,
+		Status:    chatMessage.Status,
+		At:        at,
+	}
+
+	pushFrame([]uint{chatMessage.SenderID}, FrameReceipt, "", receipt)
+}
+
+// PushNotification sends a notification to every connection userId has
+// open, on this instance or any other.
+func PushNotification(userId uint, notification *models.Notification, unread int64) {
+	pushFrame([]uint{userId}, FrameNotification, "", NotificationPayload{Notification: notification, Unread: unread})
+}
+
 func HandleWs(ctx *gin.Context) {
 	conn, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
 	if err != nil {
@@ -54,22 +240,41 @@ func HandleWs(ctx *gin.Context) {
 	defer conn.Close()
 
 	userId := ctx.GetUint("userId")
-	WSManager.AddClient(userId, conn)
+	client := WSManager.AddClient(userId, conn)
 	zap.S().Infof("User %d connected", userId)
-
-	client, _ := WSManager.GetClient(userId)
+	presence.refresh(userId)
 
 	defer func() {
-		WSManager.RemoveClient(userId)
+		if WSManager.RemoveClient(client) {
+			zap.S().Infof("User %d went offline", userId)
+		}
+		presence.refresh(userId)
 		client.Conn.Close()
 		zap.S().Infof("User %d disconnected", userId)
 	}()
 
+	go func() {
+		if err := mRouter.DeliverPending(client); err != nil {
+			zap.S().Errorf("failed to deliver pending messages: %v", err)
+		}
+	}()
+
 	go readPump(client)
 	writePump(client)
 }
 
 func readPump(client *Client) {
+	defer close(client.done)
+
+	limiter := newFrameLimiter(frameRate, frameBurst)
+
+	// A peer that sends nothing, not even a pong, within PongWait is dead
+	client.Conn.SetReadLimit(client.config.MaxMessageSize)
+	client.Conn.SetReadDeadline(time.Now().Add(client.config.PongWait))
+	client.Conn.SetPongHandler(func(string) error {
+		return client.Conn.SetReadDeadline(time.Now().Add(client.config.PongWait))
+	})
+
 	for {
 		_, message, err := client.Conn.ReadMessage()
 		if err != nil {
@@ -78,31 +283,141 @@ func readPump(client *Client) {
 			}
 			break
 		}
+		client.Conn.SetReadDeadline(time.Now().Add(client.config.PongWait))
 
-		var msg Message
-		if err := json.Unmarshal(message, &msg); err != nil {
-			zap.S().Errorf("failed to unmarshal message: %v", err)
+		var envelope Envelope
+		if err := json.Unmarshal(message, &envelope); err != nil {
+			client.sendError("", ErrCodeBadFrame, "frame is not a valid envelope")
 			continue
 		}
 
-		if err := validate.Struct(msg); err != nil {
-			zap.S().Error("To address is not a valid email")
+		if envelope.V != ProtocolVersion {
+			client.sendError(envelope.ID, ErrCodeUnsupportedVersion, "unsupported protocol version")
 			continue
 		}
 
-		zap.S().Infof("Routing message to %s: %s", msg.To, msg.Msg)
-		if err := mRouter.RouteMessage(msg); err != nil {
-			zap.S().Errorf("failed to route message: %v", err)
+		if !limiter.allow() {
+			client.sendError(envelope.ID, ErrCodeRateLimited, "too many frames, slow down")
+			continue
 		}
+
+		dispatch(client, envelope)
+	}
+}
+
+func dispatch(client *Client, envelope Envelope) {
+	switch envelope.Type {
+	case FrameMessage:
+		handleMessageFrame(client, envelope)
+	case FrameRead:
+		handleReadFrame(client, envelope)
+	case FrameTyping:
+		handleTypingFrame(client, envelope)
+	case FramePresence:
+		handlePresenceFrame(client, envelope)
+	case FramePing:
+		client.sendFrame(FramePong, envelope.ID, nil)
+	default:
+		client.sendError(envelope.ID, ErrCodeUnknownType, "unsupported frame type: "+envelope.Type)
+	}
+}
+
+// decodeFrame unpacks and validates the frame's data into payload. When the
+// data is unusable it answers with an error frame and returns false.
+func decodeFrame(client *Client, envelope Envelope, payload interface{}) bool {
+	if err := json.Unmarshal(envelope.Data, payload); err != nil {
+		client.sendError(envelope.ID, ErrCodeBadFrame, envelope.Type+" data is malformed")
+		return false
+	}
+
+	if err := validate.Struct(payload); err != nil {
+		client.sendError(envelope.ID, ErrCodeValidation, err.Error())
+		return false
+	}
+
+	return true
+}
+
+func handleMessageFrame(client *Client, envelope Envelope) {
+	var payload SendMessagePayload
+	if !decodeFrame(client, envelope, &payload) {
+		return
+	}
+
+	chatMessage, queued, err := SendMessage(client.Id, payload)
+	if errors.Is(err, ErrInvalidAddress) {
+		client.sendError(envelope.ID, ErrCodeValidation, err.Error())
+		return
+	}
+	if errors.Is(err, ErrUnknownRecipient) {
+		client.sendError(envelope.ID, ErrCodeUnknownRecipient, err.Error())
+		return
+	}
+	if errors.Is(err, ErrNotRoomMember) {
+		client.sendError(envelope.ID, ErrCodeNotRoomMember, err.Error())
+		return
+	}
+	if err != nil {
+		zap.S().Errorf("failed to route message: %v", err)
+		client.sendError(envelope.ID, ErrCodeInternal, "failed to send message")
+		return
 	}
+
+	client.sendFrame(FrameAck, envelope.ID, AckPayload{MessageID: chatMessage.ID, Status: chatMessage.Status, Queued: queued})
+}
+
+func handleReadFrame(client *Client, envelope Envelope) {
+	var payload ReadPayload
+	if !decodeFrame(client, envelope, &payload) {
+		return
+	}
+
+	if err := mRouter.MarkRead(client.Id, payload.MessageIDs); err != nil {
+		zap.S().Errorf("failed to mark messages read: %v", err)
+		client.sendError(envelope.ID, ErrCodeInternal, "failed to mark messages read")
+		return
+	}
+
+	client.sendFrame(FrameAck, envelope.ID, nil)
 }
 
 func writePump(client *Client) {
-	for msg := range client.SendChan {
-		err := client.Conn.WriteMessage(websocket.TextMessage, msg)
-		if err != nil {
-			zap.S().Errorf("failed to write message: %v", err)
-			break
+	ticker := time.NewTicker(client.config.PingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case out := <-client.SendChan:
+			client.Conn.SetWriteDeadline(time.Now().Add(client.config.WriteWait))
+			err := client.Conn.WriteMessage(websocket.TextMessage, out.Frame)
+			if err != nil {
+				zap.S().Errorf("failed to write message: %v", err)
+				return
+			}
+
+			if out.MessageID != 0 {
+				if err := mRouter.ConfirmDelivery(out.MessageID, out.SenderID); err != nil {
+					zap.S().Errorf("failed to record delivery of message %d: %v", out.MessageID, err)
+				}
+				client.forget(out.MessageID)
+			}
+
+			// once the buffer has drained, replay whatever was dropped
+			if len(client.SendChan) == 0 && client.catchUp() {
+				go func() {
+					if err := mRouter.DeliverPending(client); err != nil {
+						zap.S().Errorf("failed to replay dropped messages: %v", err)
+					}
+				}()
+			}
+		case <-ticker.C:
+			client.Conn.SetWriteDeadline(time.Now().Add(client.config.WriteWait))
+			if err := client.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				zap.S().Infof("ping to user %d failed, dropping connection: %v", client.Id, err)
+				return
+			}
+		case <-client.done:
+			return
 		}
 	}
 }
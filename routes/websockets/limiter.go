@@ -0,0 +1,37 @@
+package websockets
+
+import "time"
+
+// frameLimiter is a token bucket for the frames a single connection sends.
+// It is only used from that connection's readPump, so it needs no locking.
+type frameLimiter struct {
+	rate     float64
+	capacity float64
+	tokens   float64
+	last     time.Time
+}
+
+func newFrameLimiter(rate, burst int) *frameLimiter {
+	return &frameLimiter{
+		rate:     float64(rate),
+		capacity: float64(burst),
+		tokens:   float64(burst),
+		last:     time.Now(),
+	}
+}
+
+func (l *frameLimiter) allow() bool {
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.capacity {
+		l.tokens = l.capacity
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+
+	l.tokens--
+	return true
+}
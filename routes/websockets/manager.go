@@ -4,45 +4,249 @@ import (
 	"sync"
 
 	"github.com/gorilla/websocket"
+	"github.com/tenkorangjr/circle-app/models"
+	"go.uber.org/zap"
 )
 
+// Outbound is a frame waiting in a client's SendChan. Frames that carry a
+// chat message name it, so writePump can record delivery once it is written.
+type Outbound struct {
+	Frame     []byte
+	MessageID uint
+	SenderID  uint
+}
+
 type Client struct {
-	Id       uint
+	Id uint
+	// Conn is nil for a client reading an event stream instead
 	Conn     *websocket.Conn
-	SendChan chan []byte
+	SendChan chan Outbound
+	config   Config
+
+	// done is closed once the read side of the connection has gone away
+	done chan struct{}
+
+	mutex  sync.Mutex
+	queued map[uint]struct{}
+	away   bool
+	// behind is set when chat messages were dropped for this connection and
+	// need replaying once its buffer drains
+	behind bool
+
+	// evicted is closed when the connection is dropped for not keeping up
+	evicted chan struct{}
+	evict   sync.Once
 }
 
+func (c *Client) setAway(away bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.away = away
+}
+
+func (c *Client) isAway() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.away
+}
+
+// send queues a frame for the connection without ever blocking the caller.
+// It reports false if the frame was not queued, either because the
+// connection has closed or because it is too far behind to take more.
+func (c *Client) send(out Outbound) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+
+	select {
+	case c.SendChan <- out:
+		return true
+	default:
+		c.overflow(out)
+		return false
+	}
+}
+
+// overflow handles a frame that did not fit in SendChan. A chat message is
+// still undelivered in the database, so it is forgotten here to be replayed
+// later; anything else is simply lost. Under SlowConsumerDisconnect the
+// connection is closed as well.
+func (c *Client) overflow(out Outbound) {
+	metrics.Add("dropped_frames", 1)
+
+	if out.MessageID != 0 {
+		metrics.Add("dropped_messages", 1)
+
+		c.mutex.Lock()
+		delete(c.queued, out.MessageID)
+		c.behind = true
+		c.mutex.Unlock()
+	}
+
+	if c.config.SlowConsumerPolicy == SlowConsumerDisconnect {
+		c.evict.Do(func() {
+			metrics.Add("slow_consumer_disconnects", 1)
+			zap.S().Warnf("user %d is not keeping up, closing connection", c.Id)
+			close(c.evicted)
+			if c.Conn != nil {
+				c.Conn.Close()
+			}
+		})
+	}
+}
+
+// catchUp reports whether messages were dropped for this connection since
+// the last call, clearing the flag.
+func (c *Client) catchUp() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	behind := c.behind
+	c.behind = false
+	return behind
+}
+
+// sendMessage queues a chat message frame unless this connection has already
+// been given that message, which happens when a live push races with the
+// replay of pending messages on connect. It reports whether the connection
+// has the message queued.
+func (c *Client) sendMessage(chatMessage *models.ChatMessage, frame []byte) bool {
+	c.mutex.Lock()
+	_, seen := c.queued[chatMessage.ID]
+	c.queued[chatMessage.ID] = struct{}{}
+	c.mutex.Unlock()
+
+	if seen {
+		return true
+	}
+
+	return c.send(Outbound{Frame: frame, MessageID: chatMessage.ID, SenderID: chatMessage.SenderID})
+}
+
+// forget drops a message from queued once it has been written. It is
+// recorded as delivered by then, so a replay won't pick it up again, and
+// forgetting it keeps queued from growing for as long as the connection
+// lives.
+func (c *Client) forget(messageId uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.queued, messageId)
+}
+
+func (c *Client) sendFrame(frameType, id string, data interface{}) {
+	frame, err := newFrame(frameType, id, data)
+	if err != nil {
+		zap.S().Errorf("failed to encode %s frame: %v", frameType, err)
+		return
+	}
+
+	c.send(Outbound{Frame: frame})
+}
+
+func (c *Client) sendError(id, code, message string) {
+	c.sendFrame(FrameError, id, ErrorPayload{Code: code, Message: message})
+}
+
+// WebSocketManager tracks every open connection per user, since the same
+// user may be signed in on several devices at once.
 type WebSocketManager struct {
-	clients map[uint]*Client
+	clients map[uint]map[*Client]struct{}
 	mutex   sync.RWMutex
 }
 
-func (m *WebSocketManager) AddClient(userId uint, conn *websocket.Conn) {
+func (m *WebSocketManager) AddClient(userId uint, conn *websocket.Conn) *Client {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	m.clients[userId] = &Client{
+	settings := *config.Load()
+	client := &Client{
 		Id:       userId,
 		Conn:     conn,
-		SendChan: make(chan []byte, 256),
+		SendChan: make(chan Outbound, settings.SendBufferSize),
+		config:   settings,
+		done:     make(chan struct{}),
+		evicted:  make(chan struct{}),
+		queued:   make(map[uint]struct{}),
+	}
+
+	if m.clients[userId] == nil {
+		m.clients[userId] = make(map[*Client]struct{})
 	}
+	m.clients[userId][client] = struct{}{}
+
+	return client
 }
 
-func (m *WebSocketManager) RemoveClient(userId uint) {
+// RemoveClient drops a single connection and reports whether it was the
+// user's last one.
+func (m *WebSocketManager) RemoveClient(client *Client) bool {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	delete(m.clients, userId)
+	connections := m.clients[client.Id]
+	delete(connections, client)
+	if len(connections) > 0 {
+		return false
+	}
+
+	delete(m.clients, client.Id)
+	return true
+}
+
+// GetClients returns a snapshot of the user's open connections.
+func (m *WebSocketManager) GetClients(userId uint) []*Client {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	result := make([]*Client, 0, len(m.clients[userId]))
+	for client := range m.clients[userId] {
+		result = append(result, client)
+	}
+	return result
+}
+
+// Users lists everyone with a connection open on this instance.
+func (m *WebSocketManager) Users() []uint {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	result := make([]uint, 0, len(m.clients))
+	for userId := range m.clients {
+		result = append(result, userId)
+	}
+	return result
+}
+
+func (m *WebSocketManager) IsOnline(userId uint) bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return len(m.clients[userId]) > 0
 }
 
-func (m *WebSocketManager) GetClient(userId uint) (*Client, bool) {
+// Status is online if any of the user's connections is active, away if all
+// of them have said so, and offline when there are none.
+func (m *WebSocketManager) Status(userId uint) string {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
-	result, ok := m.clients[userId]
-	return result, ok
+	if len(m.clients[userId]) == 0 {
+		return PresenceOffline
+	}
+
+	for client := range m.clients[userId] {
+		if !client.isAway() {
+			return PresenceOnline
+		}
+	}
+	return PresenceAway
 }
 
 var WSManager = &WebSocketManager{
-	clients: make(map[uint]*Client),
+	clients: make(map[uint]map[*Client]struct{}),
 }
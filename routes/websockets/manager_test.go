@@ -0,0 +1,68 @@
+package websockets
+
+import (
+	"expvar"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tenkorangjr/circle-app/models"
+)
+
+func TestManagerTracksEveryConnection(t *testing.T) {
+	manager := &WebSocketManager{clients: make(map[uint]map[*Client]struct{})}
+
+	phone := manager.AddClient(1, nil)
+	laptop := manager.AddClient(1, nil)
+	assert.Len(t, manager.GetClients(1), 2)
+
+	assert.False(t, manager.RemoveClient(phone))
+	assert.True(t, manager.IsOnline(1))
+	assert.Equal(t, []*Client{laptop}, manager.GetClients(1))
+
+	assert.True(t, manager.RemoveClient(laptop))
+	assert.False(t, manager.IsOnline(1))
+}
+
+func droppedMessages() int64 {
+	if value, ok := metrics.Get("dropped_messages").(*expvar.Int); ok {
+		return value.Value()
+	}
+	return 0
+}
+
+func TestSlowClientDropsInsteadOfBlocking(t *testing.T) {
+	manager := &WebSocketManager{clients: make(map[uint]map[*Client]struct{})}
+	client := manager.AddClient(1, nil)
+	client.SendChan = make(chan Outbound, 1)
+
+	chatMessage := &models.ChatMessage{SenderID: 2}
+	chatMessage.ID = 7
+	before := droppedMessages()
+
+	assert.True(t, client.send(Outbound{Frame: []byte("presence")}))
+	assert.False(t, client.sendMessage(chatMessage, []byte("message")))
+	assert.Equal(t, before+1, droppedMessages())
+	assert.True(t, client.catchUp())
+	assert.False(t, client.catchUp())
+
+	// the dropped message is forgotten, so a replay can queue it again
+	<-client.SendChan
+	assert.True(t, client.sendMessage(chatMessage, []byte("message")))
+	assert.Equal(t, uint(7), (<-client.SendChan).MessageID)
+}
+
+func TestWrittenMessagesAreForgotten(t *testing.T) {
+	manager := &WebSocketManager{clients: make(map[uint]map[*Client]struct{})}
+	client := manager.AddClient(1, nil)
+
+	for id := uint(1); id <= 3; id++ {
+		chatMessage := &models.ChatMessage{SenderID: 2}
+		chatMessage.ID = id
+		assert.True(t, client.sendMessage(chatMessage, []byte("message")))
+	}
+
+	for i := 0; i < 3; i++ {
+		client.forget((<-client.SendChan).MessageID)
+	}
+	assert.Empty(t, client.queued)
+}
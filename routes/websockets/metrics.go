@@ -0,0 +1,31 @@
+package websockets
+
+import (
+	"expvar"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// metrics counts frames that had to be dropped because a connection could
+// not keep up. They are published through expvar as the "websockets" map,
+// and served by HandleMetrics:
+//
+//	dropped_frames             frames of any kind that did not fit
+//	dropped_messages           chat messages among them, left for replay
+//	slow_consumer_disconnects  connections closed under SlowConsumerDisconnect
+var metrics = newMetrics()
+
+func newMetrics() *expvar.Map {
+	m := expvar.NewMap("websockets")
+	// start every counter at zero so that they are all listed from the start
+	for _, name := range []string{"dropped_frames", "dropped_messages", "slow_consumer_disconnects"} {
+		m.Add(name, 0)
+	}
+	return m
+}
+
+// HandleMetrics answers GET /chat/metrics with the counters above.
+func HandleMetrics(ctx *gin.Context) {
+	ctx.Data(http.StatusOK, "application/json; charset=utf-8", []byte(metrics.String()))
+}
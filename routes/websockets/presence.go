@@ -0,0 +1,174 @@
+package websockets
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tenkorangjr/circle-app/db"
+	"github.com/tenkorangjr/circle-app/models"
+	"go.uber.org/zap"
+)
+
+const (
+	PresenceOnline  = "online"
+	PresenceAway    = "away"
+	PresenceOffline = "offline"
+)
+
+const maxPresenceQuery = 100
+
+// PresencePayload is pushed to a user's friends whenever their status
+// changes, and returned by GET /presence.
+type PresencePayload struct {
+	UserID   uint       `json:"user_id"`
+	Status   string     `json:"status"`
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+}
+
+// SetPresencePayload is sent by a client to mark itself away or back online.
+type SetPresencePayload struct {
+	Status string `validate:"required,oneof=online away" json:"status"`
+}
+
+// presenceTracker remembers the last status announced for each user so that
+// connection churn that doesn't change what friends see stays quiet. Every
+// instance announces to the friends connected to it.
+type presenceTracker struct {
+	mutex     sync.Mutex
+	announced map[uint]string
+}
+
+var presence = &presenceTracker{
+	announced: make(map[uint]string),
+}
+
+// refresh recomputes userId's status from their connections here, shares it
+// with the other instances and announces any change.
+func (p *presenceTracker) refresh(userId uint) {
+	publish(BusEvent{Kind: eventPresence, Presence: map[uint]string{userId: WSManager.Status(userId)}})
+	p.announce(userId, true)
+}
+
+// announce tells userId's friends connected to this instance if their status
+// across the cluster has changed. Only the instance where the change
+// happened records last-seen.
+func (p *presenceTracker) announce(userId uint, origin bool) {
+	status := clusterStatus(userId)
+
+	p.mutex.Lock()
+	previous, ok := p.announced[userId]
+	if !ok {
+		previous = PresenceOffline
+	}
+	if status == PresenceOffline {
+		delete(p.announced, userId)
+	} else {
+		p.announced[userId] = status
+	}
+	p.mutex.Unlock()
+
+	if status == previous {
+		return
+	}
+
+	payload := PresencePayload{UserID: userId, Status: status}
+	if status == PresenceOffline || previous == PresenceOffline {
+		lastSeen := time.Now()
+		if origin {
+			var err error
+			lastSeen, err = models.TouchLastSeen(db.DB, userId)
+			if err != nil {
+				zap.S().Errorf("failed to record last seen for user %d: %v", userId, err)
+			}
+		}
+		payload.LastSeen = &lastSeen
+	}
+
+	friendIds, err := models.FriendIDs(db.DB, userId)
+	if err != nil {
+		zap.S().Errorf("failed to load friends of user %d: %v", userId, err)
+		return
+	}
+
+	for _, friendId := range friendIds {
+		for _, client := range WSManager.GetClients(friendId) {
+			client.sendFrame(FramePresence, "", payload)
+		}
+	}
+}
+
+func handlePresenceFrame(client *Client, envelope Envelope) {
+	var payload SetPresencePayload
+	if !decodeFrame(client, envelope, &payload) {
+		return
+	}
+
+	client.setAway(payload.Status == PresenceAway)
+	presence.refresh(client.Id)
+	client.sendFrame(FrameAck, envelope.ID, nil)
+}
+
+// HandlePresence answers GET /presence?ids=1,2,3 with the status of each of
+// those users that is a friend of the caller. Anyone else is left out.
+func HandlePresence(ctx *gin.Context) {
+	userId := ctx.GetUint("userId")
+
+	var requested []uint
+	for _, raw := range strings.Split(ctx.Query("ids"), ",") {
+		if raw == "" {
+			continue
+		}
+		id, err := strconv.Atoi(raw)
+		if err != nil || id < 1 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"message": "ids must be a comma separated list of user ids"})
+			return
+		}
+		requested = append(requested, uint(id))
+	}
+	if len(requested) == 0 || len(requested) > maxPresenceQuery {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "between 1 and 100 ids are required"})
+		return
+	}
+
+	friendIds, err := models.FriendIDs(db.DB, userId)
+	if err != nil {
+		zap.S().Error("Failed to load friends", zap.Error(err))
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "failed to load presence"})
+		return
+	}
+	friends := make(map[uint]struct{}, len(friendIds))
+	for _, id := range friendIds {
+		friends[id] = struct{}{}
+	}
+
+	var visible []uint
+	for _, id := range requested {
+		if _, ok := friends[id]; ok {
+			visible = append(visible, id)
+		}
+	}
+
+	var users []models.User
+	if len(visible) > 0 {
+		if err := db.DB.Select("id", "last_seen_at").Where("id IN ?", visible).Find(&users).Error; err != nil {
+			zap.S().Error("Failed to load presence", zap.Error(err))
+			ctx.JSON(http.StatusInternalServerError, gin.H{"message": "failed to load presence"})
+			return
+		}
+	}
+
+	result := make([]PresencePayload, 0, len(users))
+	for _, user := range users {
+		result = append(result, PresencePayload{
+			UserID:   user.ID,
+			Status:   clusterStatus(user.ID),
+			LastSeen: user.LastSeenAt,
+		})
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"presence": result})
+}
@@ -0,0 +1,116 @@
+package websockets
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/tenkorangjr/circle-app/models"
+)
+
+// ProtocolVersion is the envelope version this server speaks. Frames with a
+// different v are rejected so old clients fail loudly instead of silently.
+const ProtocolVersion = 1
+
+const (
+	FrameMessage  = "message"
+	FrameAck      = "ack"
+	FrameError    = "error"
+	FrameTyping   = "typing"
+	FrameRead     = "read"
+	FrameReceipt  = "receipt"
+	FramePresence = "presence"
+	FramePing     = "ping"
+	FramePong     = "pong"
+	// FrameNotification is pushed by the server when the user is notified
+	FrameNotification = "notification"
+)
+
+const (
+	ErrCodeBadFrame           = "bad_frame"
+	ErrCodeUnsupportedVersion = "unsupported_version"
+	ErrCodeUnknownType        = "unknown_type"
+	ErrCodeValidation         = "validation_failed"
+	ErrCodeUnknownRecipient   = "unknown_recipient"
+	ErrCodeNotRoomMember      = "not_room_member"
+	ErrCodeRateLimited        = "rate_limited"
+	ErrCodeInternal           = "internal_error"
+)
+
+// Envelope wraps every frame sent over /chat in either direction. ID is
+// chosen by the client and echoed back on the ack or error it causes, so
+// clients can match responses to what they sent.
+type Envelope struct {
+	V    int             `json:"v"`
+	Type string          `json:"type"`
+	ID   string          `json:"id,omitempty"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// SendMessagePayload is the data of a message frame sent by a client. It is
+// addressed either to a user's email or to a room ID, never both.
+type SendMessagePayload struct {
+	To   string `validate:"omitempty,email" json:"to,omitempty"`
+	Room uint   `json:"room,omitempty"`
+	Body string `validate:"required,max=4000" json:"body"`
+}
+
+// MessagePayload is the data of a message frame pushed to a receiver. Room is
+// set when the message was sent to a room rather than directly.
+type MessagePayload struct {
+	ID     uint      `json:"id"`
+	From   uint      `json:"from"`
+	Room   uint      `json:"room,omitempty"`
+	Body   string    `json:"body"`
+	SentAt time.Time `json:"sent_at"`
+}
+
+// AckPayload confirms a client frame. For a direct message, Queued means no
+// connection of the receiver could take it right now; it will be delivered
+// once one can.
+type AckPayload struct {
+	MessageID uint   `json:"message_id"`
+	Status    string `json:"status"`
+	Queued    bool   `json:"queued,omitempty"`
+}
+
+// ReadPayload is sent by a receiver to mark messages as read.
+type ReadPayload struct {
+	MessageIDs []uint `validate:"required,min=1,max=100" json:"message_ids"`
+}
+
+// ReceiptPayload tells a sender that one of their messages changed state.
+type ReceiptPayload struct {
+	MessageID uint      `json:"message_id"`
+	Status    string    `json:"status"`
+	At        time.Time `json:"at"`
+}
+
+// NotificationPayload carries a new or updated notification along with the
+// user's unread count.
+type NotificationPayload struct {
+	Notification *models.Notification `json:"notification"`
+	Unread       int64                `json:"unread"`
+}
+
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func newFrame(frameType, id string, data interface{}) ([]byte, error) {
+	var raw json.RawMessage
+	if data != nil {
+		var err error
+		raw, err = json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(Envelope{
+		V:    ProtocolVersion,
+		Type: frameType,
+		ID:   id,
+		Data: raw,
+	})
+}
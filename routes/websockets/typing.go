@@ -0,0 +1,195 @@
+package websockets
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/tenkorangjr/circle-app/db"
+	"github.com/tenkorangjr/circle-app/models"
+	"go.uber.org/zap"
+)
+
+const (
+	TypingStarted = "started"
+	TypingStopped = "stopped"
+)
+
+const (
+	// how long a started indicator lasts without being refreshed
+	typingTimeout = 6 * time.Second
+	// minimum gap between started frames relayed for the same conversation
+	typingThrottle = 2 * time.Second
+)
+
+// SendTypingPayload is sent by a client while its user is composing a
+// message, addressed the same way as a message frame.
+type SendTypingPayload struct {
+	To    string `validate:"omitempty,email" json:"to,omitempty"`
+	Room  uint   `json:"room,omitempty"`
+	State string `validate:"required,oneof=started stopped" json:"state"`
+}
+
+// TypingPayload is relayed to the other side of the conversation.
+type TypingPayload struct {
+	From  uint   `json:"from"`
+	Room  uint   `json:"room,omitempty"`
+	State string `json:"state"`
+}
+
+type typingKey struct {
+	from uint
+	to   uint
+	room uint
+}
+
+type typingState struct {
+	relay       func(state string)
+	timer       *time.Timer
+	lastRelayed time.Time
+}
+
+// typingTracker relays typing indicators without storing them. A started
+// indicator that is not refreshed within timeout is stopped on the sender's
+// behalf, and repeated started frames are relayed at most once per throttle.
+type typingTracker struct {
+	mutex    sync.Mutex
+	active   map[typingKey]*typingState
+	timeout  time.Duration
+	throttle time.Duration
+}
+
+var typing = newTypingTracker(typingTimeout, typingThrottle)
+
+func newTypingTracker(timeout, throttle time.Duration) *typingTracker {
+	return &typingTracker{
+		active:   make(map[typingKey]*typingState),
+		timeout:  timeout,
+		throttle: throttle,
+	}
+}
+
+func (t *typingTracker) started(key typingKey, relay func(state string)) {
+	t.mutex.Lock()
+	state, ok := t.active[key]
+	if ok {
+		state.timer.Stop()
+	} else {
+		state = &typingState{relay: relay}
+		t.active[key] = state
+	}
+	state.timer = time.AfterFunc(t.timeout, func() { t.expire(key, state) })
+
+	now := time.Now()
+	shouldRelay := now.Sub(state.lastRelayed) >= t.throttle
+	if shouldRelay {
+		state.lastRelayed = now
+	}
+	t.mutex.Unlock()
+
+	if shouldRelay {
+		relay(TypingStarted)
+	}
+}
+
+func (t *typingTracker) stopped(key typingKey) {
+	t.mutex.Lock()
+	state, ok := t.active[key]
+	if ok {
+		state.timer.Stop()
+		delete(t.active, key)
+	}
+	t.mutex.Unlock()
+
+	if ok {
+		state.relay(TypingStopped)
+	}
+}
+
+func (t *typingTracker) expire(key typingKey, state *typingState) {
+	t.mutex.Lock()
+	if t.active[key] != state {
+		t.mutex.Unlock()
+		return
+	}
+	delete(t.active, key)
+	t.mutex.Unlock()
+
+	state.relay(TypingStopped)
+}
+
+func handleTypingFrame(client *Client, envelope Envelope) {
+	var payload SendTypingPayload
+	if !decodeFrame(client, envelope, &payload) {
+		return
+	}
+
+	if (payload.To == "") == (payload.Room == 0) {
+		client.sendError(envelope.ID, ErrCodeValidation, "typing must be addressed to exactly one of to or room")
+		return
+	}
+
+	key, relay, err := typingTarget(client.Id, payload)
+	if errors.Is(err, ErrUnknownRecipient) {
+		client.sendError(envelope.ID, ErrCodeUnknownRecipient, err.Error())
+		return
+	}
+	if errors.Is(err, ErrNotRoomMember) {
+		client.sendError(envelope.ID, ErrCodeNotRoomMember, err.Error())
+		return
+	}
+	if err != nil {
+		zap.S().Errorf("failed to resolve typing target: %v", err)
+		client.sendError(envelope.ID, ErrCodeInternal, "failed to send typing indicator")
+		return
+	}
+
+	if payload.State == TypingStarted {
+		typing.started(key, relay)
+	} else {
+		typing.stopped(key)
+	}
+
+	// acked even when the throttle holds the relay back, since the frame was
+	// still accepted
+	client.sendFrame(FrameAck, envelope.ID, nil)
+}
+
+// typingTarget works out who should see senderId's indicator and returns a
+// function that pushes a given state to them.
+func typingTarget(senderId uint, payload SendTypingPayload) (typingKey, func(string), error) {
+	if payload.Room != 0 {
+		if !models.IsRoomMember(db.DB, payload.Room, senderId) {
+			return typingKey{}, nil, ErrNotRoomMember
+		}
+
+		roomId := payload.Room
+		relay := func(state string) {
+			memberIds, err := models.RoomMemberIDs(db.DB, roomId)
+			if err != nil {
+				zap.S().Errorf("failed to load members of room %d: %v", roomId, err)
+				return
+			}
+
+			recipients := make([]uint, 0, len(memberIds))
+			for _, memberId := range memberIds {
+				if memberId != senderId {
+					recipients = append(recipients, memberId)
+				}
+			}
+			pushFrame(recipients, FrameTyping, "", TypingPayload{From: senderId, Room: roomId, State: state})
+		}
+		return typingKey{from: senderId, room: roomId}, relay, nil
+	}
+
+	var receiver models.User
+	if err := db.DB.Select("id").Where("email = ?", payload.To).First(&receiver).Error; err != nil {
+		return typingKey{}, nil, ErrUnknownRecipient
+	}
+
+	receiverId := receiver.ID
+	relay := func(state string) {
+		pushFrame([]uint{receiverId}, FrameTyping, "", TypingPayload{From: senderId, State: state})
+	}
+	return typingKey{from: senderId, to: receiverId}, relay, nil
+}
@@ -0,0 +1,57 @@
+package websockets
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type relayed struct {
+	mutex  sync.Mutex
+	states []string
+}
+
+func (r *relayed) relay(state string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.states = append(r.states, state)
+}
+
+func (r *relayed) get() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]string(nil), r.states...)
+}
+
+func TestTypingThrottlesRepeatedStarts(t *testing.T) {
+	tracker := newTypingTracker(time.Minute, time.Minute)
+	key := typingKey{from: 1, to: 2}
+	sink := &relayed{}
+
+	tracker.started(key, sink.relay)
+	tracker.started(key, sink.relay)
+	tracker.started(key, sink.relay)
+	tracker.stopped(key)
+
+	assert.Equal(t, []string{TypingStarted, TypingStopped}, sink.get())
+
+	// a stop with nothing active is not relayed
+	tracker.stopped(key)
+	assert.Len(t, sink.get(), 2)
+}
+
+func TestTypingExpiresWithoutStop(t *testing.T) {
+	tracker := newTypingTracker(20*time.Millisecond, time.Minute)
+	sink := &relayed{}
+
+	tracker.started(typingKey{from: 1, room: 3}, sink.relay)
+
+	assert.Eventually(t, func() bool {
+		states := sink.get()
+		return len(states) == 2 && states[1] == TypingStopped
+	}, time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2/google"
+)
+
+type GCSStore struct {
+	bucket          string
+	credentialsFile string
+}
+
+func NewGCSStore(bucket, credentialsFile string) *GCSStore {
+	return &GCSStore{
+		bucket:          bucket,
+		credentialsFile: credentialsFile,
+	}
+}
+
+func (s *GCSStore) Put(ctx context.Context, name string, r io.Reader) error {
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	wc := client.Bucket(s.bucket).Object(name).NewWriter(ctx)
+	if _, err = io.Copy(wc, r); err != nil {
+		wc.Close()
+		return err
+	}
+
+	if err := wc.Close(); err != nil {
+		return err
+	}
+
+	zap.S().Infof("Blob uploaded successfully: %s", name)
+	return nil
+}
+
+func (s *GCSStore) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := client.Bucket(s.bucket).Object(name).NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		client.Close()
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return &gcsReader{Reader: reader, client: client}, nil
+}
+
+func (s *GCSStore) Delete(ctx context.Context, name string) error {
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	err = client.Bucket(s.bucket).Object(name).Delete(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *GCSStore) SignedURL(ctx context.Context, name string, expires time.Duration) (string, error) {
+	saKey, err := os.ReadFile(s.credentialsFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read service account key")
+	}
+
+	cfg, err := google.JWTConfigFromJSON(saKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to read config file with service account key")
+	}
+
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	opts := &storage.SignedURLOptions{
+		GoogleAccessID: cfg.Email,
+		PrivateKey:     cfg.PrivateKey,
+		Scheme:         storage.SigningSchemeV4,
+		Method:         "GET",
+		Expires:        time.Now().Add(expires),
+	}
+
+	url, err := client.Bucket(s.bucket).SignedURL(name, opts)
+	if err != nil {
+		return "", fmt.Errorf("Bucket(%q).SignedURL: %w", s.bucket, err)
+	}
+
+	return url, nil
+}
+
+// gcsReader closes the client that opened it along with the object.
+type gcsReader struct {
+	*storage.Reader
+	client *storage.Client
+}
+
+func (r *gcsReader) Close() error {
+	defer r.client.Close()
+	return r.Reader.Close()
+}
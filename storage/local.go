@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LocalFilesPath is where ServeLocalFile must be mounted for the URLs handed
+// out by LocalStore.SignedURL to resolve.
+const LocalFilesPath = "/files"
+
+var ErrInvalidName = errors.New("invalid object name")
+
+// LocalStore keeps objects in a directory on disk and serves them through
+// ServeLocalFile using HMAC-signed, expiring URLs, mirroring how GCS signed
+// URLs behave.
+type LocalStore struct {
+	root    string
+	baseURL string
+	secret  []byte
+	now     func() time.Time
+}
+
+func NewLocalStore(root, baseURL string, secret []byte) (*LocalStore, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &LocalStore{
+		root:    root,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		secret:  secret,
+		now:     time.Now,
+	}, nil
+}
+
+func (s *LocalStore) Put(ctx context.Context, name string, r io.Reader) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so readers never see a partial object
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *LocalStore) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (s *LocalStore) Delete(ctx context.Context, name string) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *LocalStore) SignedURL(ctx context.Context, name string, expires time.Duration) (string, error) {
+	if _, err := s.path(name); err != nil {
+		return "", err
+	}
+
+	expiresAt := strconv.FormatInt(s.now().Add(expires).Unix(), 10)
+	query := url.Values{
+		"expires":   {expiresAt},
+		"signature": {s.sign(name, expiresAt)},
+	}
+
+	return fmt.Sprintf("%s%s/%s?%s", s.baseURL, LocalFilesPath, name, query.Encode()), nil
+}
+
+// ServeLocalFile is the gin handler behind LocalStore signed URLs. It expects
+// the object name in the *name path parameter.
+func ServeLocalFile(gc *gin.Context) {
+	store, ok := Bucket.(*LocalStore)
+	if !ok {
+		gc.JSON(http.StatusNotFound, gin.H{"message": "not found"})
+		return
+	}
+
+	store.serve(gc)
+}
+
+func (s *LocalStore) serve(gc *gin.Context) {
+	name := strings.TrimPrefix(gc.Param("name"), "/")
+	expiresAt := gc.Query("expires")
+
+	expiry, err := strconv.ParseInt(expiresAt, 10, 64)
+	if err != nil || !s.verify(name, expiresAt, gc.Query("signature")) {
+		gc.JSON(http.StatusForbidden, gin.H{"message": "invalid signature"})
+		return
+	}
+	if s.now().Unix() > expiry {
+		gc.JSON(http.StatusForbidden, gin.H{"message": "url has expired"})
+		return
+	}
+
+	path, err := s.path(name)
+	if err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		gc.JSON(http.StatusNotFound, gin.H{"message": "not found"})
+		return
+	}
+
+	gc.File(path)
+}
+
+func (s *LocalStore) sign(name, expiresAt string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(name + "\n" + expiresAt))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *LocalStore) verify(name, expiresAt, signature string) bool {
+	expected, err := hex.DecodeString(s.sign(name, expiresAt))
+	if err != nil {
+		return false
+	}
+	given, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(expected, given)
+}
+
+// path maps an object name onto the filesystem, refusing anything that would
+// escape the store's root.
+func (s *LocalStore) path(name string) (string, error) {
+	clean := filepath.Clean("/" + name)
+	if name == "" || clean == "/" || clean != "/"+name {
+		return "", ErrInvalidName
+	}
+
+	return filepath.Join(s.root, filepath.FromSlash(clean)), nil
+}
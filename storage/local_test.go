@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalStoreRoundTrip(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir(), "http://localhost:8080", []byte("secret"))
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	assert.NoError(t, store.Put(ctx, "1/2.jpg", strings.NewReader("image")))
+
+	reader, err := store.Get(ctx, "1/2.jpg")
+	assert.NoError(t, err)
+	content, _ := io.ReadAll(reader)
+	reader.Close()
+	assert.Equal(t, "image", string(content))
+
+	assert.NoError(t, store.Delete(ctx, "1/2.jpg"))
+	_, err = store.Get(ctx, "1/2.jpg")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.ErrorIs(t, store.Put(ctx, "../escape.jpg", strings.NewReader("")), ErrInvalidName)
+}
+
+func TestLocalStoreSignedURL(t *testing.T) {
+	store, _ := NewLocalStore(t.TempDir(), "http://localhost:8080", []byte("secret"))
+	store.Put(context.Background(), "1/2.jpg", strings.NewReader("image"))
+
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	Bucket = store
+	defer func() { Bucket = nil }()
+
+	server := gin.New()
+	server.GET(LocalFilesPath+"/*name", ServeLocalFile)
+
+	fetch := func(rawURL string) *httptest.ResponseRecorder {
+		parsed, _ := url.Parse(rawURL)
+		req, _ := http.NewRequest("GET", parsed.RequestURI(), nil)
+		responseWriter := httptest.NewRecorder()
+		server.ServeHTTP(responseWriter, req)
+		return responseWriter
+	}
+
+	signedURL, err := store.SignedURL(context.Background(), "1/2.jpg", time.Minute)
+	assert.NoError(t, err)
+
+	responseWriter := fetch(signedURL)
+	assert.Equal(t, http.StatusOK, responseWriter.Code)
+	assert.Equal(t, "image", responseWriter.Body.String())
+
+	// signature is bound to the object name
+	assert.Equal(t, http.StatusForbidden, fetch(strings.Replace(signedURL, "1/2.jpg", "1/3.jpg", 1)).Code)
+
+	now = now.Add(2 * time.Minute)
+	assert.Equal(t, http.StatusForbidden, fetch(signedURL).Code)
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+// Store is a blob store for user uploads. Object names are slash separated
+// paths such as "12/34.jpg".
+type Store interface {
+	Put(ctx context.Context, name string, r io.Reader) error
+	Get(ctx context.Context, name string) (io.ReadCloser, error)
+	Delete(ctx context.Context, name string) error
+	// SignedURL returns a URL that lets anyone GET the object until it expires.
+	SignedURL(ctx context.Context, name string, expires time.Duration) (string, error)
+}
+
+// Bucket is the store configured for this process by Init.
+var Bucket Store
+
+// Init picks the backend named by STORAGE_BACKEND: "gcs" (the default) or
+// "local" for a directory on disk.
+func Init() {
+	switch backend := getenv("STORAGE_BACKEND", "gcs"); backend {
+	case "gcs":
+		Bucket = NewGCSStore(
+			getenv("BUCKET_NAME", "circle_app_posts"),
+			getenv("GCS_CREDENTIALS_FILE", "./sa-cred.json"),
+		)
+	case "local":
+		secret := os.Getenv("STORAGE_SIGNING_KEY")
+		if secret == "" {
+			log.Fatal("STORAGE_SIGNING_KEY must be set to use local storage")
+		}
+
+		store, err := NewLocalStore(
+			getenv("LOCAL_STORAGE_DIR", "./uploads"),
+			getenv("PUBLIC_BASE_URL", "http://localhost:8080"),
+			[]byte(secret),
+		)
+		if err != nil {
+			log.Fatalf("Failed to set up local storage: %v", err)
+		}
+		Bucket = store
+	default:
+		log.Fatalf("Unknown STORAGE_BACKEND %q", backend)
+	}
+}
+
+func getenv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -2,48 +2,179 @@ package utils
 
 import (
 	"errors"
+	"fmt"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var tokenString = os.Getenv("JWT_KEY")
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 30 * 24 * time.Hour
 
-func GenerateJWT(userId uint, email string) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"email":   email,
-		"user_id": userId,
-		"exp":     time.Now().Add(time.Hour * 2).Unix(),
+	TokenTypeAccess = "access"
+
+	// allowed for clocks on other machines being slightly off
+	tokenLeeway = 30 * time.Second
+)
+
+// What can be wrong with a token, so that callers can say which.
+var (
+	ErrTokenMalformed        = errors.New("token is malformed")
+	ErrTokenSignatureInvalid = errors.New("token signature is invalid")
+	ErrTokenExpired          = errors.New("token has expired")
+	ErrTokenNotYetValid      = errors.New("token is not valid yet")
+	ErrTokenWrongIssuer      = errors.New("token was issued by someone else")
+	ErrTokenWrongAudience    = errors.New("token is not meant for this service")
+	ErrTokenWrongType        = errors.New("token is not an access token")
+)
+
+// Claims is what an access token says about who holds it. The subject is
+// the user's ID.
+type Claims struct {
+	jwt.RegisteredClaims
+
+	Email     string `json:"email"`
+	SessionID uint   `json:"sid"`
+	TokenType string `json:"token_type"`
+}
+
+// UserID returns the ID of the user the token was issued to.
+func (c *Claims) UserID() uint {
+	id, _ := strconv.ParseUint(c.Subject, 10, 64)
+	return uint(id)
+}
+
+// Validate checks the claims the jwt package doesn't know about. It is
+// called while parsing, after the standard claims have been checked.
+func (c *Claims) Validate() error {
+	if c.UserID() == 0 || c.SessionID == 0 || c.ID == "" || c.IssuedAt == nil {
+		return ErrTokenMalformed
+	}
+	if c.TokenType != TokenTypeAccess {
+		return ErrTokenWrongType
+	}
+
+	return nil
+}
+
+func GenerateJWT(userId uint, email string, sessionId uint) (string, error) {
+	manager := SigningKeys()
+	if manager == nil {
+		return "", ErrNoSigningKey
+	}
+
+	key, err := manager.signingKey()
+	if err != nil {
+		return "", err
+	}
+
+	jti, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(key.method, &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer(),
+			Subject:   strconv.FormatUint(uint64(userId), 10),
+			Audience:  jwt.ClaimStrings{tokenAudience()},
+			ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL)),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        jti,
+		},
+		Email:     email,
+		SessionID: sessionId,
+		TokenType: TokenTypeAccess,
 	})
+	token.Header["kid"] = key.ID
 
-	return token.SignedString([]byte(tokenString))
+	return token.SignedString(key.private)
 }
 
-func ValidateToken(token string) (uint, error) {
-	parsedToken, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
-		_, ok := t.Method.(*jwt.SigningMethodHMAC)
-		if !ok {
-			return nil, errors.New("unexpected signin method")
+// ValidateToken checks an access token's signature and every one of its
+// claims, and returns them. Errors wrap one of the ErrToken values above,
+// or ErrUnknownKey.
+func ValidateToken(token string) (*Claims, error) {
+	manager := SigningKeys()
+	if manager == nil {
+		return nil, ErrNoSigningKey
+	}
+
+	parser := jwt.NewParser(
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithIssuer(tokenIssuer()),
+		jwt.WithAudience(tokenAudience()),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(tokenLeeway),
+	)
+
+	var claims Claims
+	_, err := parser.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, err := manager.verifyingKey(kid)
+		if err != nil {
+			return nil, err
 		}
 
-		return []byte(tokenString), nil
-	})
+		// the algorithm is fixed by the key, never chosen by the token
+		if t.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("%w: signed with %s", ErrTokenSignatureInvalid, t.Method.Alg())
+		}
 
+		return key.private.Public(), nil
+	})
 	if err != nil {
-		return 0, err
+		return nil, classifyTokenError(err)
 	}
 
-	if !parsedToken.Valid {
-		return 0, errors.New("token is invalid")
+	return &claims, nil
+}
+
+// classifyTokenError maps the jwt package's errors onto ours.
+func classifyTokenError(err error) error {
+	for _, known := range []error{
+		ErrUnknownKey,
+		ErrTokenSignatureInvalid,
+		ErrTokenWrongType,
+		ErrTokenMalformed,
+	} {
+		if errors.Is(err, known) {
+			return known
+		}
 	}
 
-	claims, ok := parsedToken.Claims.(jwt.MapClaims)
-	if !ok {
-		return 0, errors.New("invalid claims type")
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return ErrTokenExpired
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return ErrTokenNotYetValid
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		return ErrTokenWrongIssuer
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		return ErrTokenWrongAudience
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+		return ErrTokenSignatureInvalid
+	default:
+		return ErrTokenMalformed
 	}
+}
 
-	user_id := uint(claims["user_id"].(float64))
+func tokenIssuer() string {
+	if issuer := os.Getenv("JWT_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "circle-app"
+}
 
-	return user_id, nil
+func tokenAudience() string {
+	if audience := os.Getenv("JWT_AUDIENCE"); audience != "" {
+		return audience
+	}
+	return "circle-api"
 }
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateTokenClassifiesErrors(t *testing.T) {
+	_, private, _ := ed25519.GenerateKey(rand.Reader)
+	key, _ := NewSigningKey("test", private, time.Time{})
+	manager, _ := NewKeyManager(key)
+	UseSigningKeys(manager)
+	defer UseSigningKeys(nil)
+
+	now := time.Now()
+	valid := func() *Claims {
+		return &Claims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    tokenIssuer(),
+				Subject:   "7",
+				Audience:  jwt.ClaimStrings{tokenAudience()},
+				ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+				IssuedAt:  jwt.NewNumericDate(now),
+				ID:        "jti",
+			},
+			SessionID: 3,
+			TokenType: TokenTypeAccess,
+		}
+	}
+	sign := func(claims *Claims) string {
+		token := jwt.NewWithClaims(key.method, claims)
+		token.Header["kid"] = key.ID
+		signed, _ := token.SignedString(key.private)
+		return signed
+	}
+
+	claims, err := ValidateToken(sign(valid()))
+	assert.NoError(t, err)
+	assert.Equal(t, uint(7), claims.UserID())
+
+	expired := valid()
+	expired.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Hour))
+	wrongAudience := valid()
+	wrongAudience.Audience = jwt.ClaimStrings{"someone-else"}
+	wrongIssuer := valid()
+	wrongIssuer.Issuer = "someone-else"
+	early := valid()
+	early.NotBefore = jwt.NewNumericDate(now.Add(time.Hour))
+	refresh := valid()
+	refresh.TokenType = "refresh"
+	noSubject := valid()
+	noSubject.Subject = "not a number"
+	noExpiry := valid()
+	noExpiry.ExpiresAt = nil
+
+	cases := map[string]struct {
+		token    string
+		expected error
+	}{
+		"expired":        {sign(expired), ErrTokenExpired},
+		"wrong audience": {sign(wrongAudience), ErrTokenWrongAudience},
+		"wrong issuer":   {sign(wrongIssuer), ErrTokenWrongIssuer},
+		"not yet valid":  {sign(early), ErrTokenNotYetValid},
+		"wrong type":     {sign(refresh), ErrTokenWrongType},
+		"bad subject":    {sign(noSubject), ErrTokenMalformed},
+		"no expiry":      {sign(noExpiry), ErrTokenMalformed},
+		"garbage":        {"not.a.token", ErrTokenMalformed},
+		"tampered":       {sign(valid()) + "x", ErrTokenSignatureInvalid},
+	}
+	for name, c := range cases {
+		_, err := ValidateToken(c.token)
+		assert.ErrorIs(t, err, c.expected, name)
+	}
+}
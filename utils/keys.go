@@ -0,0 +1,275 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrNoSigningKey = errors.New("no JWT signing key is active")
+	ErrUnknownKey   = errors.New("token was signed with an unknown key")
+)
+
+// SigningKey is one of the keys access tokens are signed with. A key signs
+// from ActiveFrom until the next key takes over, and carries on verifying
+// for as long as the tokens it signed can still be valid.
+type SigningKey struct {
+	ID         string
+	ActiveFrom time.Time
+
+	method  jwt.SigningMethod
+	private crypto.Signer
+}
+
+// NewSigningKey wraps an RSA or Ed25519 private key, signing RS256 or EdDSA
+// respectively.
+func NewSigningKey(id string, private crypto.Signer, activeFrom time.Time) (*SigningKey, error) {
+	key := &SigningKey{ID: id, ActiveFrom: activeFrom, private: private}
+
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("key %q: RSA keys must be at least 2048 bits", id)
+		}
+		key.method = jwt.SigningMethodRS256
+	case ed25519.PrivateKey:
+		key.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("key %q: unsupported key type %T", id, private)
+	}
+
+	return key, nil
+}
+
+// KeyManager holds every signing key, old and upcoming, and picks which one
+// signs and which ones verify as time passes.
+type KeyManager struct {
+	keys []*SigningKey // oldest ActiveFrom first
+	now  func() time.Time
+}
+
+func NewKeyManager(keys ...*SigningKey) (*KeyManager, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoSigningKey
+	}
+
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if key.ID == "" {
+			return nil, errors.New("every signing key needs a kid")
+		}
+		if seen[key.ID] {
+			return nil, fmt.Errorf("duplicate signing key %q", key.ID)
+		}
+		seen[key.ID] = true
+	}
+
+	sorted := append([]*SigningKey(nil), keys...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].ActiveFrom.Before(sorted[j].ActiveFrom)
+	})
+
+	return &KeyManager{keys: sorted, now: time.Now}, nil
+}
+
+// signingKey returns the key that signs new tokens: the most recent one to
+// have become active.
+func (m *KeyManager) signingKey() (*SigningKey, error) {
+	now := m.now()
+	for i := len(m.keys) - 1; i >= 0; i-- {
+		if !m.keys[i].ActiveFrom.After(now) {
+			return m.keys[i], nil
+		}
+	}
+
+	return nil, ErrNoSigningKey
+}
+
+// verifyingKeys returns every key tokens may currently be signed with.
+// Upcoming keys are included so that other services can pick them up ahead
+// of the switch, and a replaced key is kept until the last token it signed
+// has expired, leeway included.
+func (m *KeyManager) verifyingKeys() []*SigningKey {
+	now := m.now()
+
+	keys := make([]*SigningKey, 0, len(m.keys))
+	for i, key := range m.keys {
+		if i+1 < len(m.keys) && !m.keys[i+1].ActiveFrom.Add(AccessTokenTTL+tokenLeeway).After(now) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (m *KeyManager) verifyingKey(id string) (*SigningKey, error) {
+	for _, key := range m.verifyingKeys() {
+		if key.ID == id {
+			return key, nil
+		}
+	}
+
+	return nil, ErrUnknownKey
+}
+
+// JWK is a public key in the JSON Web Key format.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+// JWKS returns the public half of every key tokens may currently be signed
+// with, for other services to verify them.
+func (m *KeyManager) JWKS() []JWK {
+	keys := m.verifyingKeys()
+
+	jwks := make([]JWK, 0, len(keys))
+	for _, key := range keys {
+		jwk := JWK{KeyID: key.ID, Use: "sig", Algorithm: key.method.Alg()}
+
+		switch public := key.private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		jwks = append(jwks, jwk)
+	}
+	return jwks
+}
+
+// keyManifestEntry is how a key is listed in the file JWT_KEYS_FILE points
+// to. PrivateKey is the path of a PEM file, relative to the manifest.
+type keyManifestEntry struct {
+	ID         string    `json:"kid"`
+	PrivateKey string    `json:"private_key"`
+	ActiveFrom time.Time `json:"active_from"`
+}
+
+// LoadKeyManager reads the key manifest at path. It lists keys as
+// [{"kid": "...", "private_key": "keys/....pem", "active_from": "..."}], with
+// active_from optional for a key that should sign straight away.
+func LoadKeyManager(path string) (*KeyManager, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []keyManifestEntry
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	keys := make([]*SigningKey, 0, len(entries))
+	for _, entry := range entries {
+		keyPath := entry.PrivateKey
+		if !filepath.IsAbs(keyPath) {
+			keyPath = filepath.Join(filepath.Dir(path), keyPath)
+		}
+
+		private, err := readPrivateKey(keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", entry.ID, err)
+		}
+
+		key, err := NewSigningKey(entry.ID, private, entry.ActiveFrom)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return NewKeyManager(keys...)
+}
+
+func readPrivateKey(path string) (crypto.Signer, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+	return signer, nil
+}
+
+var (
+	keysMutex   sync.RWMutex
+	signingKeys *KeyManager
+)
+
+// UseSigningKeys swaps the keys access tokens are signed and verified with.
+func UseSigningKeys(manager *KeyManager) {
+	keysMutex.Lock()
+	defer keysMutex.Unlock()
+
+	signingKeys = manager
+}
+
+// SigningKeys returns the keys in use, or nil before any are set up.
+func SigningKeys() *KeyManager {
+	keysMutex.RLock()
+	defer keysMutex.RUnlock()
+
+	return signingKeys
+}
+
+// InitSigningKeys loads the keys listed in JWT_KEYS_FILE, refusing to start
+// without any.
+func InitSigningKeys() {
+	path := os.Getenv("JWT_KEYS_FILE")
+	if path == "" {
+		log.Fatal("JWT_KEYS_FILE must be set to sign access tokens")
+	}
+
+	manager, err := LoadKeyManager(path)
+	if err != nil {
+		log.Fatalf("Failed to load JWT signing keys: %v", err)
+	}
+	if _, err := manager.signingKey(); err != nil {
+		log.Fatalf("Failed to load JWT signing keys: %v", err)
+	}
+
+	UseSigningKeys(manager)
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyRotation(t *testing.T) {
+	start := time.Now()
+
+	_, oldPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	oldKey, _ := NewSigningKey("old", oldPrivate, start.Add(-time.Hour))
+	newPrivate, _ := rsa.GenerateKey(rand.Reader, 2048)
+	newKey, err := NewSigningKey("new", newPrivate, start.Add(time.Hour))
+	assert.NoError(t, err)
+
+	manager, err := NewKeyManager(newKey, oldKey)
+	assert.NoError(t, err)
+	manager.now = func() time.Time { return start }
+	UseSigningKeys(manager)
+	defer UseSigningKeys(nil)
+
+	oldToken, err := GenerateJWT(1, "alice@circle.app", 2)
+	assert.NoError(t, err)
+	parsed, _, _ := jwt.NewParser().ParseUnverified(oldToken, jwt.MapClaims{})
+	assert.Equal(t, "old", parsed.Header["kid"])
+	assert.Equal(t, "EdDSA", parsed.Header["alg"])
+
+	// the upcoming key is published before it starts signing
+	jwks := manager.JWKS()
+	assert.Len(t, jwks, 2)
+	assert.Equal(t, "OKP", jwks[0].KeyType)
+	assert.Equal(t, "RSA", jwks[1].KeyType)
+
+	// once it takes over, the old key still verifies what it signed
+	manager.now = func() time.Time { return start.Add(time.Hour + time.Minute) }
+	newToken, err := GenerateJWT(1, "alice@circle.app", 2)
+	assert.NoError(t, err)
+	parsed, _, _ = jwt.NewParser().ParseUnverified(newToken, jwt.MapClaims{})
+	assert.Equal(t, "new", parsed.Header["kid"])
+
+	claims, err := ValidateToken(oldToken)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(1), claims.UserID())
+	assert.Equal(t, uint(2), claims.SessionID)
+
+	// including a token signed just before the switch that is only still
+	// accepted thanks to the leeway
+	manager.now = func() time.Time { return start.Add(time.Hour + AccessTokenTTL + tokenLeeway - time.Second) }
+	_, err = ValidateToken(oldToken)
+	assert.NoError(t, err)
+
+	// and is dropped when nothing it signed can still be valid
+	manager.now = func() time.Time { return start.Add(time.Hour + AccessTokenTTL + tokenLeeway) }
+	_, err = ValidateToken(oldToken)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	assert.Len(t, manager.JWKS(), 1)
+	_, err = ValidateToken(newToken)
+	assert.NoError(t, err)
+}
+
+func TestSymmetricTokensAreRejected(t *testing.T) {
+	_, private, _ := ed25519.GenerateKey(rand.Reader)
+	key, _ := NewSigningKey("only", private, time.Time{})
+	manager, _ := NewKeyManager(key)
+	UseSigningKeys(manager)
+	defer UseSigningKeys(nil)
+
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": 1,
+		"sid":     1,
+		"exp":     time.Now().Add(time.Minute).Unix(),
+	})
+	forged.Header["kid"] = "only"
+	token, _ := forged.SignedString([]byte(""))
+
+	_, err := ValidateToken(token)
+	assert.Error(t, err)
+}
+
+func TestLoadKeyManager(t *testing.T) {
+	dir := t.TempDir()
+
+	_, private, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(private)
+	keyFile := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "2026-10.pem"), keyFile, 0o600))
+
+	manifest := `[{"kid": "2026-10", "private_key": "2026-10.pem"}]`
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "keys.json"), []byte(manifest), 0o600))
+
+	manager, err := LoadKeyManager(filepath.Join(dir, "keys.json"))
+	assert.NoError(t, err)
+	key, err := manager.signingKey()
+	assert.NoError(t, err)
+	assert.Equal(t, "2026-10", key.ID)
+	assert.Equal(t, "EdDSA", key.method.Alg())
+
+	_, err = LoadKeyManager(filepath.Join(dir, "missing.json"))
+	assert.Error(t, err)
+}
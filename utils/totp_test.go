@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTOTPMatchesRFC6238(t *testing.T) {
+	// the SHA1 test secret from RFC 6238, truncated to six digits
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, expected := range vectors {
+		code, err := TOTPCode(secret, TOTPStep(time.Unix(unix, 0)))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, code)
+	}
+}
+
+func TestValidateTOTPAllowsDrift(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	assert.NoError(t, err)
+
+	now := time.Now()
+	previous, _ := TOTPCode(secret, TOTPStep(now)-1)
+	step, ok := ValidateTOTP(secret, previous, now)
+	assert.True(t, ok)
+	assert.Equal(t, TOTPStep(now)-1, step)
+
+	stale, _ := TOTPCode(secret, TOTPStep(now)-3)
+	_, ok = ValidateTOTP(secret, stale, now)
+	assert.False(t, ok)
+
+	uri := TOTPURI("Circle", "alice@circle.app", secret)
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Circle:alice@circle.app?"))
+	assert.Contains(t, uri, "secret="+secret)
+}